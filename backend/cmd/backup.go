@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"planning-poker/internal/config"
+	"planning-poker/internal/room"
+	"time"
+)
+
+// backup writes every room of the configured store into a backup file
+func backup(cfg config.AppConfig, args []string) {
+	path := backupPath("backup", args)
+
+	repo, closeRepo := openRoomRepo(cfg)
+	defer closeRepo()
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	written, err := writeBackup(f, repo)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// a partial backup would only fail later, when it is needed
+		os.Remove(path)
+		log.Fatal(err)
+	}
+
+	log.Printf("Backed up %d rooms to %s", written, path)
+}
+
+func writeBackup(f *os.File, repo room.RoomRepo) (int, error) {
+	w, err := room.NewBackupWriter(f, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := room.BackupRooms(repo, w)
+	if err != nil {
+		return written, err
+	}
+
+	return written, w.Close()
+}
+
+// restore saves every room of a backup file into the configured store. The whole backup is verified
+// first, so a damaged one restores nothing.
+func restore(cfg config.AppConfig, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "replace rooms the store already has instead of skipping them")
+	flags.Parse(args)
+	path := backupPath("restore [-replace]", flags.Args())
+
+	rooms, broken, err := verifyBackup(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if broken > 0 {
+		log.Fatalf("%d of %d rooms in %s are damaged, nothing restored", broken, rooms, path)
+	}
+
+	repo, closeRepo := openRoomRepo(cfg)
+	defer closeRepo()
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := room.NewBackupReader(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	restored, skipped, err := room.RestoreRooms(r, repo, *replace)
+	if err != nil {
+		log.Fatalf("restored %d rooms before failing: %v", restored, err)
+	}
+
+	log.Printf("Restored %d rooms from %s, skipped %d already in the store", restored, path, skipped)
+}
+
+// verify checks every room of a backup file without restoring anything
+func verify(args []string) {
+	path := backupPath("verify", args)
+
+	rooms, broken, err := verifyBackup(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if broken > 0 {
+		log.Fatalf("%d of %d rooms in %s are damaged", broken, rooms, path)
+	}
+
+	log.Printf("Backup %s is intact, %d rooms", path, rooms)
+}
+
+// verifyBackup reads the whole backup, logging every damaged room. Damage outside of a room,
+// like a backup cut short, is returned as an error.
+func verifyBackup(path string) (rooms int, broken int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r, err := room.NewBackupReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	log.Printf("Backup taken at %s, format version %d", r.Header.CreatedAt.Format(time.RFC3339), r.Header.Version)
+
+	for {
+		restored, err := r.Next()
+
+		var roomErr *room.BackupRoomError
+		if errors.As(err, &roomErr) {
+			log.Println(roomErr)
+			rooms++
+			broken++
+			continue
+		}
+		if err != nil {
+			return rooms, broken, err
+		}
+		if restored == nil {
+			return rooms, broken, nil
+		}
+		rooms++
+	}
+}
+
+func backupPath(command string, args []string) string {
+	if len(args) != 1 {
+		log.Fatalf("usage: %s <backup file>", command)
+	}
+
+	return args[0]
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"planning-poker/internal/config"
+	"planning-poker/internal/database"
+	"planning-poker/internal/room"
+)
+
+// runCommand runs one of the maintenance subcommands instead of the server
+func runCommand(cfg config.AppConfig, args []string) {
+	switch args[0] {
+	case "migrate":
+		migrate(cfg)
+	case "sqlite-to-bolt":
+		sqliteToBolt(cfg)
+	case "backup":
+		backup(cfg, args[1:])
+	case "restore":
+		restore(cfg, args[1:])
+	case "verify":
+		verify(args[1:])
+	default:
+		log.Fatalf("unknown command %q, available commands: migrate, sqlite-to-bolt, backup, restore, verify", args[0])
+	}
+}
+
+func migrate(cfg config.AppConfig) {
+	db, err := database.OpenDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	err = database.Migrate(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Database at version %d", version)
+}
+
+// sqliteToBolt copies every room from the sqlite database into the bolt file, rooms already in the
+// bolt file are overwritten. The server must not be running, it holds a lock on the bolt file.
+func sqliteToBolt(cfg config.AppConfig) {
+	if cfg.BoltFilePath == "" {
+		log.Fatal("BOLT_FILE_PATH must be set")
+	}
+
+	// rooms come from the sqlite file even when a postgres url is configured, and older
+	// sqlite files get their schema brought up to date first, like the server would
+	cfg.DatabaseURL = ""
+	db := database.SetupDatabase(cfg)
+	defer db.Close()
+	from := room.NewRoomRepoSqlite(db)
+
+	to, err := room.OpenRoomRepoBolt(cfg.BoltFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer to.Close()
+
+	roomIds, err := from.ListRoomIDs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	copied, err := room.CopyRooms(roomIds, &from, &to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Copied %d rooms from %s to %s", copied, cfg.DatabaseFilePath, cfg.BoltFilePath)
+}
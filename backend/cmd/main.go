@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
+	"os"
+	"os/signal"
+	"planning-poker/internal/backplane"
 	"planning-poker/internal/config"
 	"planning-poker/internal/database"
 	"planning-poker/internal/hub"
 	"planning-poker/internal/room"
 	"planning-poker/internal/server"
+	"syscall"
 )
 
 func main() {
@@ -15,10 +21,59 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1:])
+		return
+	}
+
+	roomRepo, closeRepo := openRoomRepo(cfg)
+	defer closeRepo()
+
+	bp, err := backplane.New(cfg.BackplaneURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bp.Close()
+
+	h := hub.NewHub(cfg, roomRepo, bp)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go h.RunJanitor(ctx)
+
+	s := server.NewServer(cfg, &h, roomRepo)
+	err = s.Serve(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openRoomRepo opens the store rooms are kept in, along with a function closing it
+func openRoomRepo(cfg config.AppConfig) (room.RoomRepo, func()) {
+	// the bolt store doesn't touch the sql database, so builds without cgo never open sqlite
+	if cfg.RoomStore == config.RoomStoreBolt {
+		repo, err := room.OpenRoomRepoBolt(cfg.BoltFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return &repo, func() { repo.Close() }
+	}
+
 	db := database.SetupDatabase(cfg)
-	roomRepo := room.NewRoomRepoSqlite(db)
-	h := hub.NewHub(&roomRepo)
+	return newRoomRepo(cfg, db), func() { db.Close() }
+}
+
+func newRoomRepo(cfg config.AppConfig, db *sql.DB) room.RoomRepo {
+	switch cfg.RoomStore {
+	case config.RoomStoreEventLog:
+		repo := room.NewRoomRepoEventLog(db, cfg.SnapshotEvery)
+		return &repo
+	case config.RoomStorePostgres:
+		repo := room.NewRoomRepoPostgres(db)
+		return &repo
+	}
 
-	s := server.NewServer(cfg, &h, &roomRepo)
-	s.Serve()
+	repo := room.NewRoomRepoSqlite(db)
+	return &repo
 }
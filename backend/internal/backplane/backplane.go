@@ -0,0 +1,47 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Backplane fans messages out to every subscriber of a channel, whichever instance they run on.
+// Every subscriber of a channel gets its messages in the same order, numbered one after the other,
+// so a subscriber that missed some of them can tell.
+type Backplane interface {
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe returns once the subscription is active, so anything published afterwards is received
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+	// Subscribers counts the subscriptions to the channel, across every instance
+	Subscribers(ctx context.Context, channel string) (int, error)
+	Close() error
+}
+
+// Message is a message published on a channel
+type Message struct {
+	// Seq numbers the messages of the channel, a subscriber seeing it skip a number missed messages
+	Seq  uint64
+	Data []byte
+	// Lost stands in for messages published while the subscription was interrupted, it carries no data
+	Lost bool
+}
+
+type Subscription interface {
+	// Messages is closed once the subscription is closed. A subscription interrupted, for example by a lost
+	// connection, resumes on its own and reports the messages it missed.
+	Messages() <-chan Message
+	Close() error
+}
+
+// New picks the backplane for the url, the in-process one when it is empty
+func New(url string) (Backplane, error) {
+	switch {
+	case url == "":
+		return NewMemory(), nil
+	case strings.HasPrefix(url, "redis://") || strings.HasPrefix(url, "rediss://"):
+		return NewRedis(url)
+	}
+
+	return nil, fmt.Errorf("unsupported backplane url %q", url)
+}
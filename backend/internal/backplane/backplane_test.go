@@ -0,0 +1,197 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"testing"
+	"time"
+)
+
+// backplanes runs the test against every implementation, Redis through an in-process stand-in
+func backplanes(t *testing.T, test func(t *testing.T, bp Backplane)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		srv := miniredis.RunT(t)
+
+		bp, err := New("redis://" + srv.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bp.Close() })
+
+		test(t, bp)
+	})
+}
+
+func receive(t *testing.T, sub Subscription) string {
+	select {
+	case msg, ok := <-sub.Messages():
+		if !ok {
+			t.Fatal("Subscription closed")
+		}
+		return string(msg.Data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("No message received")
+	}
+
+	return ""
+}
+
+func TestShouldDeliverInOrderToEverySubscriber(t *testing.T) {
+	backplanes(t, func(t *testing.T, bp Backplane) {
+		ctx := context.Background()
+
+		first, err := bp.Subscribe(ctx, "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+
+		second, err := bp.Subscribe(ctx, "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+
+		for i := 0; i < 10; i++ {
+			if err := bp.Publish(ctx, "room", []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 10; i++ {
+			if msg := receive(t, first); msg != fmt.Sprint(i) {
+				t.Errorf("First subscriber got %s, expected %d", msg, i)
+			}
+			if msg := receive(t, second); msg != fmt.Sprint(i) {
+				t.Errorf("Second subscriber got %s, expected %d", msg, i)
+			}
+		}
+	})
+}
+
+func TestShouldNumberMessagesOfChannel(t *testing.T) {
+	backplanes(t, func(t *testing.T, bp Backplane) {
+		ctx := context.Background()
+
+		sub, err := bp.Subscribe(ctx, "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		bp.Publish(ctx, "other", []byte("other"))
+		for i := 0; i < 3; i++ {
+			bp.Publish(ctx, "room", []byte("mine"))
+		}
+
+		for i := uint64(1); i <= 3; i++ {
+			msg := <-sub.Messages()
+			if msg.Seq != i || msg.Lost || string(msg.Data) != "mine" {
+				t.Errorf("Expected message %d, got %+v", i, msg)
+			}
+		}
+	})
+}
+
+func TestShouldReportMessagesLostWhileReconnecting(t *testing.T) {
+	srv := miniredis.RunT(t)
+	bp, err := New("redis://" + srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Close()
+
+	sub, err := bp.Subscribe(context.Background(), "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	srv.Close()
+	if err := srv.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.Messages():
+		if !msg.Lost {
+			t.Errorf("Expected lost messages reported, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Reconnection not reported")
+	}
+}
+
+func TestShouldKeepChannelsApart(t *testing.T) {
+	backplanes(t, func(t *testing.T, bp Backplane) {
+		ctx := context.Background()
+
+		sub, err := bp.Subscribe(ctx, "room-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		bp.Publish(ctx, "room-b", []byte("other"))
+		bp.Publish(ctx, "room-a", []byte("mine"))
+
+		if msg := receive(t, sub); msg != "mine" {
+			t.Errorf("Got message from another channel: %s", msg)
+		}
+	})
+}
+
+func TestShouldCountSubscribers(t *testing.T) {
+	backplanes(t, func(t *testing.T, bp Backplane) {
+		ctx := context.Background()
+
+		for i := 0; i < 2; i++ {
+			sub, err := bp.Subscribe(ctx, "room-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+		}
+
+		if n, err := bp.Subscribers(ctx, "room-a"); err != nil || n != 2 {
+			t.Errorf("Expected 2 subscribers, got %d: %v", n, err)
+		}
+		if n, err := bp.Subscribers(ctx, "room-b"); err != nil || n != 0 {
+			t.Errorf("Expected no subscribers, got %d: %v", n, err)
+		}
+	})
+}
+
+func TestShouldStopDeliveringAfterClose(t *testing.T) {
+	backplanes(t, func(t *testing.T, bp Backplane) {
+		ctx := context.Background()
+
+		sub, err := bp.Subscribe(ctx, "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sub.Close()
+		bp.Publish(ctx, "room", []byte("late"))
+
+		select {
+		case msg, ok := <-sub.Messages():
+			if ok {
+				t.Errorf("Received %s after close", msg.Data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Error("Messages not closed")
+		}
+	})
+}
+
+func TestShouldRejectUnknownBackplane(t *testing.T) {
+	if _, err := New("nats://localhost:4222"); err == nil {
+		t.Error("Expected an error for an unsupported url")
+	}
+}
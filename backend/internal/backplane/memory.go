@@ -0,0 +1,138 @@
+package backplane
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is a backplane for a single process, every instance using it must share the same value
+type Memory struct {
+	subs map[string]map[*memorySubscription]struct{}
+	seqs map[string]uint64
+	mu   sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		subs: make(map[string]map[*memorySubscription]struct{}),
+		seqs: make(map[string]uint64),
+	}
+}
+
+func (m *Memory) Publish(_ context.Context, channel string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seqs[channel]++
+	seq := m.seqs[channel]
+
+	// pushing to every queue under the lock keeps the order the same for all subscribers
+	for sub := range m.subs[channel] {
+		sub.push(Message{Seq: seq, Data: msg})
+	}
+
+	return nil
+}
+
+func (m *Memory) Subscribe(_ context.Context, channel string) (Subscription, error) {
+	sub := &memorySubscription{
+		memory:  m,
+		channel: channel,
+		notify:  make(chan struct{}, 1),
+		out:     make(chan Message),
+		done:    make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if m.subs[channel] == nil {
+		m.subs[channel] = make(map[*memorySubscription]struct{})
+	}
+	m.subs[channel][sub] = struct{}{}
+	m.mu.Unlock()
+
+	go sub.pump()
+
+	return sub, nil
+}
+
+func (m *Memory) Subscribers(_ context.Context, channel string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.subs[channel]), nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// memorySubscription queues messages without bounds, so publishing never waits on a subscriber,
+// even when the subscriber itself publishes
+type memorySubscription struct {
+	memory  *Memory
+	channel string
+	queue   []Message
+	notify  chan struct{}
+	out     chan Message
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+func (s *memorySubscription) push(msg Message) {
+	s.mu.Lock()
+	s.queue = append(s.queue, msg)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySubscription) pump() {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		msg := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.out <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *memorySubscription) Messages() <-chan Message {
+	return s.out
+}
+
+func (s *memorySubscription) Close() error {
+	s.once.Do(func() {
+		s.memory.mu.Lock()
+		delete(s.memory.subs[s.channel], s)
+		if len(s.memory.subs[s.channel]) == 0 {
+			delete(s.memory.subs, s.channel)
+		}
+		s.memory.mu.Unlock()
+
+		close(s.done)
+	})
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package backplane
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// publishScript numbers the message and publishes it in one step, so the numbers follow the order
+// the channel delivers the messages in
+var publishScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', ARGV[1], seq .. ' ' .. ARGV[2])
+return seq
+`)
+
+// channelSize is how many messages go-redis buffers for a subscription, it drops messages once it is full
+const channelSize = 1000
+
+// Redis is a backplane over Redis pub/sub, shared by every instance connected to the same server
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+func (r *Redis) Publish(ctx context.Context, channel string, msg []byte) error {
+	return publishScript.Run(ctx, r.client, []string{seqKey(channel)}, channel, msg).Err()
+}
+
+func seqKey(channel string) string {
+	return "backplane:seq:" + channel
+}
+
+func (r *Redis) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	pubsub := r.client.Subscribe(ctx, channel)
+
+	// wait for the confirmation, otherwise messages published right after would be missed
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	sub := &redisSubscription{
+		pubsub: pubsub,
+		out:    make(chan Message),
+		done:   make(chan struct{}),
+	}
+	go sub.pump()
+
+	return sub, nil
+}
+
+func (r *Redis) Subscribers(ctx context.Context, channel string) (int, error) {
+	counts, err := r.client.PubSubNumSub(ctx, channel).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(counts[channel]), nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	out    chan Message
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *redisSubscription) pump() {
+	defer close(s.out)
+
+	// go-redis subscribes again on its own after losing the connection, and tells so
+	for received := range s.pubsub.ChannelWithSubscriptions(redis.WithChannelSize(channelSize)) {
+		var msg Message
+		switch received := received.(type) {
+		case *redis.Subscription:
+			msg = Message{Lost: true}
+		case *redis.Message:
+			var err error
+			msg, err = parseMessage(received.Payload)
+			if err != nil {
+				msg = Message{Lost: true}
+			}
+		default:
+			continue
+		}
+
+		select {
+		case s.out <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func parseMessage(payload string) (Message, error) {
+	seq, data, ok := strings.Cut(payload, " ")
+	if !ok {
+		return Message{}, errors.New("message without sequence number")
+	}
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{Seq: n, Data: []byte(data)}, nil
+}
+
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.out
+}
+
+func (s *redisSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.pubsub.Close()
+	})
+
+	return err
+}
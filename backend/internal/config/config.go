@@ -1,16 +1,51 @@
 package config
 
 import (
+	"crypto/rand"
+	"errors"
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type AppConfig struct {
-	DatabaseFilePath string
-	AdminPassword    string
+	DatabaseFilePath  string
+	DatabaseURL       string
+	DatabaseMaxConns  int
+	BoltFilePath      string
+	AdminPassword     string
+	SessionSecret     []byte
+	ResumeGracePeriod time.Duration
+	ResumeTokenTTL    time.Duration
+	SendQueueSize     int
+	PingInterval      time.Duration
+	PongTimeout       time.Duration
+	WriteTimeout      time.Duration
+	BackplaneURL      string
+	SyncTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	MigrateOnStart    bool
+	RoomStore         string
+	SnapshotEvery     int
+	RoomArchiveAfter  time.Duration
+	RoomDeleteAfter   time.Duration
+	JanitorInterval   time.Duration
+	RoomFlushInterval time.Duration
 }
 
+const (
+	// RoomStoreSqlite keeps the current state of every room in tables
+	RoomStoreSqlite = "sqlite"
+	// RoomStoreEventLog keeps every change made to a room, and rebuilds rooms by replaying them
+	RoomStoreEventLog = "eventlog"
+	// RoomStorePostgres keeps every room as jsonb in the postgres database at DATABASE_URL
+	RoomStorePostgres = "postgres"
+	// RoomStoreBolt keeps every room in the bbolt file at BOLT_FILE_PATH, and needs no cgo
+	RoomStoreBolt = "bolt"
+)
+
 func LoadConfig() (AppConfig, error) {
 	// Only tries to load the .env file when not running inside fly.io
 	if os.Getenv("FLY_MACHINE_ID") == "" {
@@ -22,8 +57,203 @@ func LoadConfig() (AppConfig, error) {
 		}
 	}
 
+	// instances given a backplane url share rooms through it
+	backplaneURL := os.Getenv("BACKPLANE_URL")
+
+	sessionSecret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(sessionSecret) == 0 {
+		// instances sharing rooms must accept each other's resume tokens
+		if backplaneURL != "" {
+			return AppConfig{}, errors.New("BACKPLANE_URL needs SESSION_SECRET, shared by every instance")
+		}
+
+		// resume tokens won't survive a restart, but sessions still work within a single process
+		log.Println("SESSION_SECRET not set, generating a random one...")
+
+		sessionSecret = make([]byte, 32)
+		_, err := rand.Read(sessionSecret)
+		if err != nil {
+			return AppConfig{}, err
+		}
+	}
+
+	resumeGracePeriod, err := durationFromEnv("RESUME_GRACE_PERIOD", 30*time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	// resume tokens are handed out again on every connection, so this only ends sessions left alone for as long
+	resumeTokenTTL, err := durationFromEnv("RESUME_TOKEN_TTL", 24*time.Hour)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if resumeTokenTTL <= 0 {
+		return AppConfig{}, errors.New("RESUME_TOKEN_TTL must be positive")
+	}
+
+	sendQueueSize, err := intFromEnv("SEND_QUEUE_SIZE", 256)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if sendQueueSize < 1 {
+		return AppConfig{}, errors.New("SEND_QUEUE_SIZE must be at least 1")
+	}
+
+	pingInterval, err := durationFromEnv("WS_PING_INTERVAL", 25*time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	pongTimeout, err := durationFromEnv("WS_PONG_TIMEOUT", 60*time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	writeTimeout, err := durationFromEnv("WS_WRITE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	// clients need at least one ping before the read deadline expires
+	if pingInterval <= 0 || pingInterval >= pongTimeout || writeTimeout <= 0 {
+		return AppConfig{}, errors.New("WS_PING_INTERVAL must be positive and shorter than WS_PONG_TIMEOUT, WS_WRITE_TIMEOUT must be positive")
+	}
+
+	// without a backplane url the instance is alone, there is nobody to sync rooms from
+	defaultSyncTimeout := time.Duration(0)
+	if backplaneURL != "" {
+		defaultSyncTimeout = 500 * time.Millisecond
+	}
+
+	syncTimeout, err := durationFromEnv("BACKPLANE_SYNC_TIMEOUT", defaultSyncTimeout)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	shutdownTimeout, err := durationFromEnv("SHUTDOWN_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	// deployments running the migrate command on their own only get the schema checked at startup
+	migrateOnStart := os.Getenv("MIGRATE_ON_START") != "false"
+
+	// a database url replaces the sqlite file, rooms are then kept in postgres
+	databaseURL := os.Getenv("DATABASE_URL")
+
+	databaseMaxConns, err := intFromEnv("DATABASE_MAX_CONNS", 10)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if databaseMaxConns < 1 {
+		return AppConfig{}, errors.New("DATABASE_MAX_CONNS must be at least 1")
+	}
+
+	roomStore := os.Getenv("ROOM_STORE")
+	if roomStore == "" {
+		roomStore = RoomStoreSqlite
+		if databaseURL != "" {
+			roomStore = RoomStorePostgres
+		}
+	}
+	switch roomStore {
+	case RoomStoreSqlite, RoomStoreEventLog:
+		if databaseURL != "" {
+			return AppConfig{}, errors.New("ROOM_STORE " + roomStore + " keeps rooms in the sqlite file, unset DATABASE_URL")
+		}
+	case RoomStorePostgres:
+		if databaseURL == "" {
+			return AppConfig{}, errors.New("ROOM_STORE postgres needs DATABASE_URL")
+		}
+	case RoomStoreBolt:
+		if os.Getenv("BOLT_FILE_PATH") == "" {
+			return AppConfig{}, errors.New("ROOM_STORE bolt needs BOLT_FILE_PATH")
+		}
+	default:
+		return AppConfig{}, errors.New("ROOM_STORE must be sqlite, eventlog, postgres or bolt")
+	}
+
+	snapshotEvery, err := intFromEnv("ROOM_SNAPSHOT_EVERY", 100)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if snapshotEvery < 1 {
+		return AppConfig{}, errors.New("ROOM_SNAPSHOT_EVERY must be at least 1")
+	}
+
+	// rooms nobody changed for this many days are archived, and deleted once archived for as many more.
+	// 0 turns either step off
+	archiveAfterDays, err := intFromEnv("ROOM_ARCHIVE_AFTER_DAYS", 30)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	deleteAfterDays, err := intFromEnv("ROOM_DELETE_AFTER_DAYS", 30)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if archiveAfterDays < 0 || deleteAfterDays < 0 {
+		return AppConfig{}, errors.New("ROOM_ARCHIVE_AFTER_DAYS and ROOM_DELETE_AFTER_DAYS can't be negative")
+	}
+
+	janitorInterval, err := durationFromEnv("JANITOR_INTERVAL", time.Hour)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if janitorInterval <= 0 {
+		return AppConfig{}, errors.New("JANITOR_INTERVAL must be positive")
+	}
+
+	// changes to a room are saved together once this long passed since the first of them
+	roomFlushInterval, err := durationFromEnv("ROOM_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if roomFlushInterval < 0 {
+		return AppConfig{}, errors.New("ROOM_FLUSH_INTERVAL can't be negative")
+	}
+
 	return AppConfig{
-		DatabaseFilePath: os.Getenv("DATABASE_FILE_PATH"),
-		AdminPassword:    os.Getenv("ADMIN_PASSWORD"),
+		DatabaseFilePath:  os.Getenv("DATABASE_FILE_PATH"),
+		DatabaseURL:       databaseURL,
+		DatabaseMaxConns:  databaseMaxConns,
+		BoltFilePath:      os.Getenv("BOLT_FILE_PATH"),
+		AdminPassword:     os.Getenv("ADMIN_PASSWORD"),
+		SessionSecret:     sessionSecret,
+		ResumeGracePeriod: resumeGracePeriod,
+		ResumeTokenTTL:    resumeTokenTTL,
+		SendQueueSize:     sendQueueSize,
+		PingInterval:      pingInterval,
+		PongTimeout:       pongTimeout,
+		WriteTimeout:      writeTimeout,
+		BackplaneURL:      backplaneURL,
+		SyncTimeout:       syncTimeout,
+		ShutdownTimeout:   shutdownTimeout,
+		MigrateOnStart:    migrateOnStart,
+		RoomStore:         roomStore,
+		SnapshotEvery:     snapshotEvery,
+		RoomArchiveAfter:  time.Duration(archiveAfterDays) * 24 * time.Hour,
+		RoomDeleteAfter:   time.Duration(deleteAfterDays) * 24 * time.Hour,
+		JanitorInterval:   janitorInterval,
+		RoomFlushInterval: roomFlushInterval,
 	}, nil
 }
+
+// durationFromEnv parses a duration such as "30s" or "5m", falling back to def when the variable is unset
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+func intFromEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(value)
+}
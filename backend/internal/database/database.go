@@ -2,22 +2,68 @@ package database
 
 import (
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/mattn/go-sqlite3"
 	"log"
 	"planning-poker/internal/config"
+	"time"
 )
 
+// Dialect names the sql flavour of a database, each one has its own migrations
+type Dialect string
+
+const (
+	DialectSqlite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+// DialectOf tells the dialect of a database from its driver
+func DialectOf(db *sql.DB) Dialect {
+	switch db.Driver().(type) {
+	case *stdlib.Driver:
+		return DialectPostgres
+	case *sqlite3.SQLiteDriver:
+		return DialectSqlite
+	}
+
+	return DialectSqlite
+}
+
+// OpenDatabase connects to postgres when a database url is configured, and to the sqlite file otherwise
+func OpenDatabase(cfg config.AppConfig) (*sql.DB, error) {
+	if cfg.DatabaseURL == "" {
+		return sql.Open("sqlite3", cfg.DatabaseFilePath)
+	}
+
+	db, err := sql.Open("pgx", cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// every room save takes a connection, the pool keeps a few of them open between saves
+	db.SetMaxOpenConns(cfg.DatabaseMaxConns)
+	db.SetMaxIdleConns(cfg.DatabaseMaxConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	return db, nil
+}
+
+// SetupDatabase opens the database and brings its schema up to date, or only checks it
+// when migrations are left to the migrate command
 func SetupDatabase(cfg config.AppConfig) *sql.DB {
 	log.Println("Setting up database...")
 
-	db, err := sql.Open("sqlite3", cfg.DatabaseFilePath)
+	db, err := OpenDatabase(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// setup tables (this should become migrations in the future)
-	log.Println("Setting up tables...")
-	_, err = db.Exec(`create table if not exists rooms (id text primary key, data jsonb)`)
+	if cfg.MigrateOnStart {
+		log.Println("Applying migrations...")
+		err = Migrate(db)
+	} else {
+		err = CheckSchema(db)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrDatabaseTooNew    = errors.New("database schema is newer than this binary, refusing to start")
+	ErrPendingMigrations = errors.New("database has pending migrations")
+)
+
+// migrationLock is the postgres advisory lock held while migrating, so instances starting together
+// don't apply the same migrations twice
+const migrationLock = 7305117
+
+// querier is a database, or a single connection to it
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Migration is a numbered sql script from the migrations folder of a dialect, named like 0001_create_rooms.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations of the dialect embedded in the binary, ordered by version
+func Migrations(dialect Dialect) ([]Migration, error) {
+	return loadMigrations(migrationFiles, path.Join("migrations", string(dialect)))
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s doesn't start with a version number", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(data),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrate applies every embedded migration the database doesn't have yet. Postgres instances migrating
+// at once take turns, the ones after the first find nothing left to apply.
+func Migrate(db *sql.DB) error {
+	migrations, err := Migrations(DialectOf(db))
+	if err != nil {
+		return err
+	}
+
+	return migrate(db, migrations)
+}
+
+// CheckSchema fails when the database isn't exactly at the version of the embedded migrations
+func CheckSchema(db *sql.DB) error {
+	migrations, err := Migrations(DialectOf(db))
+	if err != nil {
+		return err
+	}
+
+	pending, err := pendingMigrations(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w, run the migrate command", ErrPendingMigrations)
+	}
+
+	return nil
+}
+
+// SchemaVersion is the version of the last migration applied to the database, 0 when none was
+func SchemaVersion(db *sql.DB) (int, error) {
+	return schemaVersion(db)
+}
+
+func schemaVersion(q querier) (int, error) {
+	err := createMigrationsTable(q)
+	if err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = q.QueryRowContext(context.Background(), "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+func migrate(db *sql.DB, migrations []Migration) error {
+	if DialectOf(db) != DialectPostgres {
+		return applyPending(db, migrations)
+	}
+
+	// the lock belongs to the connection, migrating on it too works with a pool of a single connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	return applyPending(conn, migrations)
+}
+
+func applyPending(q querier, migrations []Migration) error {
+	pending, err := pendingMigrations(q, migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		log.Printf("Applying migration %s...", m.Name)
+
+		err := applyMigration(q, m)
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// pendingMigrations returns the migrations newer than the database, failing when the database
+// is at a version this binary doesn't know about
+func pendingMigrations(q querier, migrations []Migration) ([]Migration, error) {
+	current, err := schemaVersion(q)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	if current > latest {
+		return nil, fmt.Errorf("%w (database at version %d, binary knows up to %d)", ErrDatabaseTooNew, current, latest)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// applyMigration runs the migration and records it in the same transaction, so it is applied entirely or not at all
+func applyMigration(q querier, m Migration) error {
+	tx, err := q.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(m.SQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createMigrationsTable(q querier) error {
+	_, err := q.ExecContext(context.Background(), `create table if not exists schema_migrations (version integer primary key, name text not null, applied_at timestamp not null)`)
+
+	return err
+}
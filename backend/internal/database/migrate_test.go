@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func openTestDatabase(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestShouldApplyEmbeddedMigrations(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, _ := Migrations(DialectSqlite)
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+
+	if _, err := db.Exec("SELECT id, deck_kind, topic_order FROM rooms"); err != nil {
+		t.Error("Rooms table not created")
+	}
+
+	// running again is a no-op
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShouldLoadMigrationsInOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_last.sql":  {Data: []byte("select 1")},
+		"migrations/0002_first.sql": {Data: []byte("select 1")},
+		"migrations/README.md":      {Data: []byte("notes")},
+	}
+
+	migrations, err := loadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Version != 2 || migrations[1].Name != "0010_last" {
+		t.Errorf("Wrong migrations loaded: %v", migrations)
+	}
+
+	fsys["migrations/0002_duplicate.sql"] = &fstest.MapFile{Data: []byte("select 1")}
+	if _, err := loadMigrations(fsys, "migrations"); err == nil {
+		t.Error("Loaded migrations sharing a version")
+	}
+}
+
+func TestShouldRollbackFailedMigration(t *testing.T) {
+	db := openTestDatabase(t)
+
+	err := migrate(db, []Migration{
+		{Version: 1, Name: "0001_ok", SQL: "create table ok (id text)"},
+		{Version: 2, Name: "0002_broken", SQL: "create table half (id text); insert into missing values (1)"},
+	})
+	if err == nil {
+		t.Fatal("Broken migration applied")
+	}
+
+	if version, _ := SchemaVersion(db); version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+
+	if _, err := db.Exec("SELECT * FROM half"); err == nil {
+		t.Error("Broken migration left a table behind")
+	}
+}
+
+func TestShouldRefuseNewerDatabase(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); !errors.Is(err, ErrDatabaseTooNew) {
+		t.Errorf("Expected ErrDatabaseTooNew, got %v", err)
+	}
+	if err := CheckSchema(db); !errors.Is(err, ErrDatabaseTooNew) {
+		t.Errorf("Expected ErrDatabaseTooNew, got %v", err)
+	}
+}
+
+func TestShouldReportPendingMigrations(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := CheckSchema(db); !errors.Is(err, ErrPendingMigrations) {
+		t.Errorf("Expected ErrPendingMigrations, got %v", err)
+	}
+}
+
+func TestShouldSplitRoomBlobsIntoTables(t *testing.T) {
+	db := openTestDatabase(t)
+
+	migrations, err := Migrations(DialectSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db, migrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := `{
+		"room_id": "01HZ0000000000000000000000",
+		"created_at": "2024-05-01T10:00:00Z",
+		"topic_order": ["01HZ0000000000000000000001"],
+		"current_topic_id": "01HZ0000000000000000000001",
+		"deck": {"kind": "tshirt", "cards": ["S", "M", "L"]},
+		"timer": null,
+		"participants": {"01HZ00000000000000000000A1": {"user_id": "01HZ00000000000000000000A1", "name": "Alice", "role": "facilitator"}},
+		"topics": {"01HZ0000000000000000000001": {
+			"topic_id": "01HZ0000000000000000000001",
+			"title": "Login page",
+			"url": "",
+			"description": "",
+			"comments": [{"comment_id": "01HZ00000000000000000000C1", "content": "looks big", "created_at": "2024-05-01T10:05:00Z"}],
+			"client_votes": {"01HZ00000000000000000000A1": "M"},
+			"points": null,
+			"completed": false,
+			"votes_visible": true,
+			"stats": null,
+			"round_started_at": "2024-05-01T10:01:00Z",
+			"revealed_at": null,
+			"rounds": [{"number": 1, "votes": {"01HZ00000000000000000000A1": "L"}, "started_at": "2024-05-01T10:00:00Z", "reset_reason": "reset"}],
+			"created_at": "2024-05-01T10:00:00Z",
+			"completed_at": null
+		}}
+	}`
+	if _, err := db.Exec("INSERT INTO rooms (id, data) VALUES ('01HZ0000000000000000000000', ?)", blob); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	for table, expected := range map[string]int{"rooms": 1, "participants": 1, "topics": 1, "votes": 1, "comments": 1, "rounds": 1} {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("Expected %d rows in %s, got %d", expected, table, count)
+		}
+	}
+
+	var deckKind, title, points string
+	var votesVisible bool
+	err = db.QueryRow(`SELECT r.deck_kind, t.title, t.votes_visible, v.points FROM rooms r
+		JOIN topics t ON t.room_id = r.id JOIN votes v ON v.topic_id = t.id`).Scan(&deckKind, &title, &votesVisible, &points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deckKind != "tshirt" || title != "Login page" || !votesVisible || points != "M" {
+		t.Errorf("Wrong room converted: %s %s %v %s", deckKind, title, votesVisible, points)
+	}
+
+	if _, err := db.Exec("SELECT * FROM room_blobs"); err == nil {
+		t.Error("Blob table left behind")
+	}
+}
+
+func TestShouldEmbedMigrationsOfEveryDialect(t *testing.T) {
+	for _, dialect := range []Dialect{DialectSqlite, DialectPostgres} {
+		migrations, err := Migrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Errorf("No %s migrations embedded", dialect)
+		}
+	}
+
+	if dialect := DialectOf(openTestDatabase(t)); dialect != DialectSqlite {
+		t.Errorf("Expected sqlite dialect, got %s", dialect)
+	}
+}
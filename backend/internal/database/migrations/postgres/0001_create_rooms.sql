@@ -0,0 +1,6 @@
+-- rooms are kept whole as jsonb, postgres deployments start from this schema
+create table if not exists rooms (
+    id text primary key,
+    data jsonb not null,
+    updated_at timestamptz not null default now()
+);
@@ -0,0 +1,4 @@
+-- when every room was last changed and whether it was archived, for the janitor. rooms saved before
+-- count as active from now on, so none of them is archived right after the upgrade
+alter table rooms add column last_activity_at timestamptz not null default now();
+alter table rooms add column archived_at timestamptz;
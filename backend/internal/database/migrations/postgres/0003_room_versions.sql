@@ -0,0 +1,4 @@
+-- the stamp of the last save of every room, saves based on an older version are refused
+alter table rooms add column version bigint not null default 0;
+alter table rooms add column lineage text;
+alter table rooms add column recorded bigint not null default 0;
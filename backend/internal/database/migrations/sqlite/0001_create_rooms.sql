@@ -0,0 +1 @@
+create table if not exists rooms (id text primary key, data jsonb);
@@ -0,0 +1,127 @@
+-- rooms used to be a single json blob each, they are split into a table per entity
+-- so saving a room only writes the rows that changed
+alter table rooms rename to room_blobs;
+
+create table rooms (
+    id text primary key,
+    created_at timestamp not null,
+    current_topic_id text,
+    deck_kind text not null,
+    deck_cards text not null,
+    timer text,
+    topic_order text not null
+);
+
+create table participants (
+    room_id text not null,
+    user_id text not null,
+    name text not null,
+    role text not null,
+    primary key (room_id, user_id)
+);
+
+create table topics (
+    id text primary key,
+    room_id text not null,
+    title text not null,
+    url text not null,
+    description text not null,
+    points text,
+    completed boolean not null,
+    votes_visible boolean not null,
+    stats text,
+    round_started_at timestamp not null,
+    revealed_at timestamp,
+    created_at timestamp not null,
+    completed_at timestamp
+);
+create index topics_room_id on topics (room_id);
+
+create table votes (
+    room_id text not null,
+    topic_id text not null,
+    user_id text not null,
+    points text not null,
+    primary key (topic_id, user_id)
+);
+create index votes_room_id on votes (room_id);
+
+create table comments (
+    id text primary key,
+    room_id text not null,
+    topic_id text not null,
+    content text not null,
+    created_at timestamp not null
+);
+create index comments_room_id on comments (room_id);
+
+create table rounds (
+    room_id text not null,
+    topic_id text not null,
+    number integer not null,
+    votes text not null,
+    started_at timestamp not null,
+    revealed_at timestamp,
+    ended_at timestamp,
+    stats text,
+    reset_reason text not null,
+    primary key (topic_id, number)
+);
+create index rounds_room_id on rounds (room_id);
+
+insert into rooms (id, created_at, current_topic_id, deck_kind, deck_cards, timer, topic_order)
+select id,
+       json_extract(data, '$.created_at'),
+       json_extract(data, '$.current_topic_id'),
+       coalesce(json_extract(data, '$.deck.kind'), ''),
+       coalesce(json_extract(data, '$.deck.cards'), '[]'),
+       json_extract(data, '$.timer'),
+       coalesce(json_extract(data, '$.topic_order'), '[]')
+from room_blobs;
+
+insert into participants (room_id, user_id, name, role)
+select b.id, p.key, json_extract(p.value, '$.name'), json_extract(p.value, '$.role')
+from room_blobs b, json_each(b.data, '$.participants') p;
+
+insert into topics (id, room_id, title, url, description, points, completed, votes_visible, stats,
+                    round_started_at, revealed_at, created_at, completed_at)
+select t.key,
+       b.id,
+       coalesce(json_extract(t.value, '$.title'), ''),
+       coalesce(json_extract(t.value, '$.url'), ''),
+       coalesce(json_extract(t.value, '$.description'), ''),
+       json_extract(t.value, '$.points'),
+       coalesce(json_extract(t.value, '$.completed'), 0),
+       coalesce(json_extract(t.value, '$.votes_visible'), 0),
+       json_extract(t.value, '$.stats'),
+       coalesce(json_extract(t.value, '$.round_started_at'), json_extract(t.value, '$.created_at')),
+       json_extract(t.value, '$.revealed_at'),
+       json_extract(t.value, '$.created_at'),
+       json_extract(t.value, '$.completed_at')
+from room_blobs b, json_each(b.data, '$.topics') t;
+
+insert into votes (room_id, topic_id, user_id, points)
+select b.id, t.key, v.key, v.value
+from room_blobs b, json_each(b.data, '$.topics') t, json_each(t.value, '$.client_votes') v;
+
+insert into comments (id, room_id, topic_id, content, created_at)
+select json_extract(c.value, '$.comment_id'),
+       b.id,
+       t.key,
+       coalesce(json_extract(c.value, '$.content'), ''),
+       json_extract(c.value, '$.created_at')
+from room_blobs b, json_each(b.data, '$.topics') t, json_each(t.value, '$.comments') c;
+
+insert into rounds (room_id, topic_id, number, votes, started_at, revealed_at, ended_at, stats, reset_reason)
+select b.id,
+       t.key,
+       json_extract(r.value, '$.number'),
+       coalesce(json_extract(r.value, '$.votes'), '{}'),
+       json_extract(r.value, '$.started_at'),
+       json_extract(r.value, '$.revealed_at'),
+       json_extract(r.value, '$.ended_at'),
+       json_extract(r.value, '$.stats'),
+       coalesce(json_extract(r.value, '$.reset_reason'), '')
+from room_blobs b, json_each(b.data, '$.topics') t, json_each(t.value, '$.rounds') r;
+
+drop table room_blobs;
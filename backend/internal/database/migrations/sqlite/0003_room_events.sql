@@ -0,0 +1,18 @@
+-- the journal of every room, for the event log room store. rooms are rebuilt from their latest
+-- snapshot and the events recorded after it
+create table room_events (
+    room_id text not null,
+    number integer not null,
+    type text not null,
+    data text not null,
+    recorded_at timestamp not null,
+    primary key (room_id, number)
+);
+
+create table room_snapshots (
+    room_id text not null,
+    number integer not null,
+    data text not null,
+    taken_at timestamp not null,
+    primary key (room_id, number)
+);
@@ -0,0 +1,15 @@
+-- when every room was last changed and whether it was archived, for the janitor. rooms saved before
+-- count as active from now on, so none of them is archived right after the upgrade
+alter table rooms add column last_activity_at timestamp not null default '1970-01-01 00:00:00';
+alter table rooms add column archived_at timestamp;
+update rooms set last_activity_at = CURRENT_TIMESTAMP;
+
+-- the same for rooms of the event log room store, kept up to date on every save
+create table room_activity (
+    room_id text primary key,
+    last_activity_at timestamp not null,
+    archived_at timestamp
+);
+
+insert into room_activity (room_id, last_activity_at)
+select room_id, CURRENT_TIMESTAMP from room_snapshots group by room_id;
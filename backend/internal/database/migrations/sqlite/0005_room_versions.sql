@@ -0,0 +1,12 @@
+-- the stamp of the last save of every room, saves based on an older version are refused
+alter table rooms add column version integer not null default 0;
+alter table rooms add column lineage text;
+alter table rooms add column recorded integer not null default 0;
+
+-- the same for rooms of the event log room store
+create table room_versions (
+    room_id text primary key,
+    version integer not null,
+    lineage text,
+    recorded integer not null
+);
@@ -1,6 +1,9 @@
 package hub
 
-import "github.com/oklog/ulid/v2"
+import (
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+)
 
 type AddTopicCommand struct {
 	Title   string `json:"title"`
@@ -19,6 +22,7 @@ type CompleteTopicCommand struct {
 
 type ResetTopicVotesCommand struct {
 	TopicID ulid.ULID `json:"topic_id"`
+	Reason  string    `json:"reason"`
 }
 
 type VoteOnTopicCommand struct {
@@ -45,3 +49,31 @@ type ChangeTopicDetails struct {
 	Desc    string    `json:"desc"`
 	Url     string    `json:"url"`
 }
+
+type SetUserRoleCommand struct {
+	UserID ulid.ULID `json:"user_id"`
+	Role   user.Role `json:"role"`
+}
+
+type ChangeDeckCommand struct {
+	Deck  string   `json:"deck"`
+	Cards []string `json:"cards"`
+}
+
+type StartTimerCommand struct {
+	Seconds    int  `json:"seconds"`
+	AutoReveal bool `json:"auto_reveal"`
+}
+
+type ExtendTimerCommand struct {
+	Seconds int `json:"seconds"`
+}
+
+type ReorderTopicsCommand struct {
+	TopicIDs []ulid.ULID `json:"topic_ids"`
+}
+
+type MoveTopicCommand struct {
+	TopicID  ulid.ULID `json:"topic_id"`
+	Position int       `json:"position"`
+}
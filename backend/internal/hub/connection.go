@@ -0,0 +1,158 @@
+package hub
+
+import (
+	"github.com/gorilla/websocket"
+	"log"
+	"planning-poker/internal/room"
+	"planning-poker/internal/user"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CloseQueueOverflow is the close code sent to clients that can't keep up with the room
+const CloseQueueOverflow = websocket.ClosePolicyViolation
+
+const (
+	closeFrameTimeout = time.Second
+	maxMessageSize    = 64 * 1024
+)
+
+// ConnectionConfig holds the queue size and the heartbeat settings of every client connection
+type ConnectionConfig struct {
+	SendQueueSize int
+	PingInterval  time.Duration
+	PongTimeout   time.Duration
+	WriteTimeout  time.Duration
+}
+
+// UserConnection is a websocket client in a room. Every write goes through its send queue,
+// which is drained by a dedicated writer goroutine, so a slow client never blocks the room.
+type UserConnection struct {
+	// the user as it connected, its current role is kept by the room
+	User user.User
+	Conn *websocket.Conn
+	Room *room.Room
+
+	cfg         ConnectionConfig
+	send        chan interface{}
+	done        chan struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+	lastMessage interface{}
+
+	// set by the hub when the client catches up, read by the room broadcaster
+	syncedSeq atomic.Uint64
+}
+
+func NewUserConnection(ws *websocket.Conn, u user.User, r *room.Room, cfg ConnectionConfig) *UserConnection {
+	return &UserConnection{
+		User:   u,
+		Conn:   ws,
+		Room:   r,
+		cfg:    cfg,
+		send:   make(chan interface{}, cfg.SendQueueSize),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// Send queues a message to the client without blocking. Clients whose queue is full are
+// disconnected, returns false when the message was dropped.
+func (userConn *UserConnection) Send(v interface{}) bool {
+	select {
+	case <-userConn.done:
+		return false
+	default:
+	}
+
+	select {
+	case userConn.send <- v:
+		return true
+	default:
+		log.Printf("send queue of user %s is full, disconnecting", userConn.User.Name)
+		userConn.Close(CloseQueueOverflow, "send queue overflow")
+		return false
+	}
+}
+
+// Close stops the writer goroutine, which sends a close frame with the given code and closes the socket
+func (userConn *UserConnection) Close(code int, reason string) {
+	userConn.CloseWith(nil, code, reason)
+}
+
+// CloseWith is like Close, sending v right before the close frame, even when the send queue is full
+func (userConn *UserConnection) CloseWith(v interface{}, code int, reason string) {
+	userConn.closeOnce.Do(func() {
+		userConn.lastMessage = v
+		userConn.closeCode = code
+		userConn.closeReason = reason
+		close(userConn.done)
+	})
+}
+
+// ReadMessage reads the next message from the client. Peers that go quiet for longer than the pong
+// timeout, without even answering pings, make it fail so they go through the normal disconnect path.
+func (userConn *UserConnection) ReadMessage() ([]byte, error) {
+	err := userConn.Conn.SetReadDeadline(time.Now().Add(userConn.cfg.PongTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, data, err := userConn.Conn.ReadMessage()
+
+	return data, err
+}
+
+// prepareRead sets up the read limit and extends the read deadline whenever the client answers a ping
+func (userConn *UserConnection) prepareRead() {
+	userConn.Conn.SetReadLimit(maxMessageSize)
+	userConn.Conn.SetPongHandler(func(string) error {
+		return userConn.Conn.SetReadDeadline(time.Now().Add(userConn.cfg.PongTimeout))
+	})
+}
+
+// writePump is the only goroutine writing to the connection, as gorilla/websocket doesn't support concurrent writers.
+// It also pings the client periodically so dead peers are detected by the read deadline.
+func (userConn *UserConnection) writePump() {
+	ticker := time.NewTicker(userConn.cfg.PingInterval)
+	defer func() {
+		ticker.Stop()
+		userConn.Conn.Close()
+		close(userConn.closed)
+	}()
+
+	for {
+		select {
+		case m := <-userConn.send:
+			_ = userConn.Conn.SetWriteDeadline(time.Now().Add(userConn.cfg.WriteTimeout))
+
+			err := userConn.Conn.WriteJSON(m)
+			if err != nil {
+				log.Printf("error writing to client: %v", err)
+				userConn.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			err := userConn.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(userConn.cfg.WriteTimeout))
+			if err != nil {
+				userConn.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-userConn.done:
+			if userConn.lastMessage != nil {
+				_ = userConn.Conn.SetWriteDeadline(time.Now().Add(closeFrameTimeout))
+				_ = userConn.Conn.WriteJSON(userConn.lastMessage)
+			}
+
+			_ = userConn.Conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(userConn.closeCode, userConn.closeReason),
+				time.Now().Add(closeFrameTimeout),
+			)
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/room"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+func TestShouldCloseConnectionWhenQueueOverflows(t *testing.T) {
+	userConn := NewUserConnection(nil, user.NewUser(ulid.Make(), "slow"), nil, ConnectionConfig{SendQueueSize: 1})
+
+	if !userConn.Send("first") {
+		t.Error("Dropped message with room in the queue")
+	}
+
+	if userConn.Send("second") {
+		t.Error("Queued message over the limit")
+	}
+
+	select {
+	case <-userConn.done:
+	default:
+		t.Fatal("Connection not closed")
+	}
+
+	if userConn.closeCode != CloseQueueOverflow {
+		t.Error("Wrong close code")
+	}
+
+	if userConn.Send("third") {
+		t.Error("Queued message on closed connection")
+	}
+}
+
+func TestShouldCatchUpOnEventsDroppedByRoom(t *testing.T) {
+	r := room.NewRoom(ulid.Make(), make(map[room.TopicID]*room.Topic), time.Now())
+	for i := 0; i < 3; i++ {
+		r.AddTopic(ulid.Make(), "Test topic", "", "")
+	}
+	activeRoom := &ActiveRoom{RoomID: r.RoomID, Room: &r, ConnectedUsers: make(map[user.UserID]*UserConnection)}
+
+	h := Hub{}
+	userConn := NewUserConnection(nil, user.NewUser(ulid.Make(), "behind"), &r, ConnectionConfig{SendQueueSize: 8})
+	userConn.syncedSeq.Store(1)
+
+	// the broadcaster got the third event, the second one was dropped
+	events, _ := r.EventsSince(2)
+	h.sendEvent(activeRoom, userConn, events[0])
+
+	for _, seq := range []uint64{2, 3} {
+		if msg := (<-userConn.send).(OutMessage); msg.Seq != seq {
+			t.Errorf("Expected event %d, got %d", seq, msg.Seq)
+		}
+	}
+	if len(userConn.send) != 0 || userConn.syncedSeq.Load() != 3 {
+		t.Error("Expected the client caught up to 3")
+	}
+}
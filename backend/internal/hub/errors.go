@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"errors"
+	"planning-poker/internal/room"
+)
+
+const (
+	ErrCodeUnknownCommand   = "UNKNOWN_COMMAND"
+	ErrCodeMalformedPayload = "MALFORMED_PAYLOAD"
+	ErrCodeTopicNotFound    = "TOPIC_NOT_FOUND"
+	ErrCodePermissionDenied = "PERMISSION_DENIED"
+	ErrCodeUserNotFound     = "USER_NOT_FOUND"
+	ErrCodeInvalidRole      = "INVALID_ROLE"
+	ErrCodeLastFacilitator  = "LAST_FACILITATOR"
+	ErrCodeInvalidCard      = "INVALID_CARD"
+	ErrCodeInvalidDeck      = "INVALID_DECK"
+	ErrCodeNoCurrentTopic   = "NO_CURRENT_TOPIC"
+	ErrCodeTimerNotRunning  = "TIMER_NOT_RUNNING"
+	ErrCodeTimerNotPaused   = "TIMER_NOT_PAUSED"
+	ErrCodeInvalidDuration  = "INVALID_DURATION"
+	ErrCodeInvalidOrder     = "INVALID_ORDER"
+	ErrCodeNoTopicsLeft     = "NO_TOPICS_LEFT"
+	ErrCodeSaveConflict     = "SAVE_CONFLICT"
+	ErrCodeInternal         = "INTERNAL_ERROR"
+)
+
+// CommandError is the error reported back to the client in an ERROR frame
+type CommandError struct {
+	Code    string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+var ErrRoomNotFound = errors.New("room not found")
+
+var (
+	ErrUnknownCommand   = &CommandError{Code: ErrCodeUnknownCommand, Message: "unknown command"}
+	ErrPermissionDenied = &CommandError{Code: ErrCodePermissionDenied, Message: "permission denied"}
+)
+
+func malformedPayload(err error) *CommandError {
+	return &CommandError{Code: ErrCodeMalformedPayload, Message: err.Error()}
+}
+
+// toCommandError maps errors returned by the room to the codes sent to clients
+func toCommandError(err error) *CommandError {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr
+	}
+
+	var conflict *room.ConflictError
+	if errors.As(err, &conflict) {
+		return &CommandError{Code: ErrCodeSaveConflict, Message: "the room was changed elsewhere, the change wasn't saved"}
+	}
+
+	switch {
+	case errors.Is(err, room.ErrTopicNotFound):
+		return &CommandError{Code: ErrCodeTopicNotFound, Message: err.Error()}
+	case errors.Is(err, room.ErrObserverCannotVote):
+		return &CommandError{Code: ErrCodePermissionDenied, Message: err.Error()}
+	case errors.Is(err, room.ErrUserNotFound):
+		return &CommandError{Code: ErrCodeUserNotFound, Message: err.Error()}
+	case errors.Is(err, room.ErrInvalidRole):
+		return &CommandError{Code: ErrCodeInvalidRole, Message: err.Error()}
+	case errors.Is(err, room.ErrLastFacilitator):
+		return &CommandError{Code: ErrCodeLastFacilitator, Message: err.Error()}
+	case errors.Is(err, room.ErrInvalidCard):
+		return &CommandError{Code: ErrCodeInvalidCard, Message: err.Error()}
+	case errors.Is(err, room.ErrInvalidDeck):
+		return &CommandError{Code: ErrCodeInvalidDeck, Message: err.Error()}
+	case errors.Is(err, room.ErrNoCurrentTopic):
+		return &CommandError{Code: ErrCodeNoCurrentTopic, Message: err.Error()}
+	case errors.Is(err, room.ErrTimerNotRunning):
+		return &CommandError{Code: ErrCodeTimerNotRunning, Message: err.Error()}
+	case errors.Is(err, room.ErrTimerNotPaused):
+		return &CommandError{Code: ErrCodeTimerNotPaused, Message: err.Error()}
+	case errors.Is(err, room.ErrInvalidDuration):
+		return &CommandError{Code: ErrCodeInvalidDuration, Message: err.Error()}
+	case errors.Is(err, room.ErrInvalidOrder):
+		return &CommandError{Code: ErrCodeInvalidOrder, Message: err.Error()}
+	case errors.Is(err, room.ErrNoTopicsLeft):
+		return &CommandError{Code: ErrCodeNoTopicsLeft, Message: err.Error()}
+	}
+
+	return &CommandError{Code: ErrCodeInternal, Message: err.Error()}
+}
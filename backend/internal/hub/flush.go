@@ -0,0 +1,245 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"planning-poker/internal/room"
+	"sync"
+	"time"
+)
+
+// Changes to an active room aren't saved right away. The room is marked dirty, and saved once the flush
+// interval passed since it was first marked, together with every change made meanwhile. Rooms are flushed
+// as well when they are deactivated and on shutdown.
+
+// writeBehind tracks the unsaved changes of an active room
+type writeBehind struct {
+	mu    sync.Mutex
+	dirty bool
+	timer *time.Timer
+
+	// flushes of a room never overlap
+	flushing sync.Mutex
+}
+
+// FlushStats tells how saving rooms in the background goes
+type FlushStats struct {
+	Flushes       uint64  `json:"flushes"`
+	Failures      uint64  `json:"failures"`
+	Conflicts     uint64  `json:"conflicts"`
+	AvgLatencyMs  float64 `json:"avg_latency_ms"`
+	MaxLatencyMs  float64 `json:"max_latency_ms"`
+	LastLatencyMs float64 `json:"last_latency_ms"`
+}
+
+type flushMetrics struct {
+	mu           sync.Mutex
+	flushes      uint64
+	failures     uint64
+	conflicts    uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	lastLatency  time.Duration
+}
+
+func (m *flushMetrics) observe(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flushes++
+	m.totalLatency += latency
+	m.lastLatency = latency
+	if latency > m.maxLatency {
+		m.maxLatency = latency
+	}
+
+	var conflict *room.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		m.conflicts++
+	case err != nil:
+		m.failures++
+	}
+}
+
+func (hub *Hub) FlushStats() FlushStats {
+	m := hub.flushMetrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := FlushStats{
+		Flushes:       m.flushes,
+		Failures:      m.failures,
+		Conflicts:     m.conflicts,
+		MaxLatencyMs:  milliseconds(m.maxLatency),
+		LastLatencyMs: milliseconds(m.lastLatency),
+	}
+	if m.flushes > 0 {
+		stats.AvgLatencyMs = milliseconds(m.totalLatency / time.Duration(m.flushes))
+	}
+
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// markDirty records that the room has changes to save, a flush is scheduled unless one already is
+func (hub *Hub) markDirty(activeRoom *ActiveRoom) {
+	wb := &activeRoom.writeBehind
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.dirty = true
+	if wb.timer == nil {
+		wb.timer = time.AfterFunc(hub.flushInterval, func() {
+			err := hub.flushRoom(activeRoom)
+			if err != nil {
+				log.Println(err)
+			}
+		})
+	}
+}
+
+// flushRoom saves the room when it has unsaved changes. A failed save is tried again after the flush interval,
+// unless it conflicts with someone else saving the room. The room's clients are told, and the room is replaced
+// with the saved one, since its changes could never be saved. Must not be called with hub.Mu held.
+func (hub *Hub) flushRoom(activeRoom *ActiveRoom) error {
+	wb := &activeRoom.writeBehind
+	wb.flushing.Lock()
+	defer wb.flushing.Unlock()
+
+	wb.mu.Lock()
+	dirty := wb.dirty
+	wb.dirty = false
+	if wb.timer != nil {
+		wb.timer.Stop()
+		wb.timer = nil
+	}
+	wb.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	start := time.Now()
+	err := hub.saveRoom(activeRoom.Room)
+	hub.flushMetrics.observe(time.Since(start), err)
+	if err == nil {
+		return nil
+	}
+
+	var conflict *room.ConflictError
+	if errors.As(err, &conflict) {
+		cmdErr := toCommandError(err)
+		for _, userConn := range hub.roomConnections(activeRoom) {
+			userConn.Send(ErrorResponse{
+				Type:    "ERROR",
+				Code:    cmdErr.Code,
+				Message: cmdErr.Message,
+			})
+		}
+
+		reloadErr := hub.reload(activeRoom)
+		if reloadErr != nil {
+			log.Println(reloadErr)
+		}
+		return err
+	}
+
+	// the repos keep what failed to save for the next save
+	hub.markDirty(activeRoom)
+	return err
+}
+
+// copyRoom copies the room, so it can be read while the room keeps changing
+func copyRoom(r *room.Room) (*room.Room, error) {
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return room.FromSnapshot(snapshot)
+}
+
+// reload replaces the room with the one in the store on every instance serving it, going through the
+// room's channel so they all replace it at the same point
+func (hub *Hub) reload(activeRoom *ActiveRoom) error {
+	stored, err := hub.repo.FindRoom(activeRoom.RoomID)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return ErrRoomNotFound
+	}
+
+	snapshot, err := stored.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	msg := hub.newMessage(msgReload)
+	msg.State = &roomSyncState{Snapshot: snapshot}
+
+	return hub.publish(activeRoom.RoomID, msg)
+}
+
+// flushDeactivated saves the last changes of a room deactivated on this instance. Activating the room
+// again waits until they are saved. Must be called with hub.Mu held.
+func (hub *Hub) flushDeactivated(activeRoom *ActiveRoom) {
+	done := make(chan struct{})
+	hub.flushes[activeRoom.RoomID] = done
+
+	go func() {
+		defer close(done)
+
+		err := hub.flushRoom(activeRoom)
+		if err != nil {
+			log.Println(err)
+		}
+
+		hub.Mu.Lock()
+		if hub.flushes[activeRoom.RoomID] == done {
+			delete(hub.flushes, activeRoom.RoomID)
+		}
+		hub.Mu.Unlock()
+	}()
+}
+
+// waitFlushed waits until the changes of the room saved on its deactivation are saved, and until the janitor
+// is done archiving or deleting it
+func (hub *Hub) waitFlushed(roomId room.RoomID) {
+	hub.Mu.Lock()
+	flushed, flushing := hub.flushes[roomId]
+	expired, expiring := hub.expiring[roomId]
+	hub.Mu.Unlock()
+
+	if flushing {
+		<-flushed
+	}
+	if expiring {
+		<-expired
+	}
+}
+
+// waitFlushes waits until the flushes of every deactivated room are done, or until ctx is done
+func (hub *Hub) waitFlushes(ctx context.Context) error {
+	hub.Mu.Lock()
+	pending := make([]chan struct{}, 0, len(hub.flushes))
+	for _, done := range hub.flushes {
+		pending = append(pending, done)
+	}
+	hub.Mu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"context"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/config"
+	"planning-poker/internal/room"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingRepo counts the saves reaching the repo
+type countingRepo struct {
+	room.RoomRepo
+	saves atomic.Int32
+}
+
+func (r *countingRepo) Save(room *room.Room) error {
+	r.saves.Add(1)
+	return r.RoomRepo.Save(room)
+}
+
+func newCountingHub(t *testing.T, cfg config.AppConfig) (*Hub, *countingRepo, room.RoomID) {
+	memory := room.NewRoomRepoMemory()
+	repo := &countingRepo{RoomRepo: &memory}
+	h := NewHub(cfg, repo, backplane.NewMemory())
+
+	r, _, err := h.CreateRoom(nil, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &h, repo, r.RoomID
+}
+
+func addTopics(t *testing.T, ws *websocket.Conn, count int) {
+	for i := 0; i < count; i++ {
+		ws.WriteJSON(map[string]interface{}{
+			"type":       "ADD_TOPIC",
+			"request_id": "1",
+			"data":       map[string]string{"title": "Topic"},
+		})
+		readUntil(t, ws, "ACK")
+	}
+}
+
+func TestShouldSaveBurstOfChangesAtOnce(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomFlushInterval = 300 * time.Millisecond
+	h, repo, roomId := newCountingHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+	addTopics(t, ws, 10)
+
+	if saves := repo.saves.Load(); saves != 1 {
+		t.Errorf("Expected only the creation saved yet, got %d saves", saves)
+	}
+
+	waitFor(t, func() bool { return repo.saves.Load() == 2 })
+
+	saved, _ := repo.FindRoom(roomId)
+	if len(saved.Topics) != 10 {
+		t.Errorf("Expected every topic saved, got %d", len(saved.Topics))
+	}
+	if stats := h.FlushStats(); stats.Flushes != 1 || stats.Failures != 0 {
+		t.Errorf("Unexpected flush stats %+v", stats)
+	}
+}
+
+func TestShouldSaveRoomWhenDeactivated(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomFlushInterval = time.Hour
+	h, repo, roomId := newCountingHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+	addTopics(t, ws, 3)
+	ws.Close()
+
+	waitFor(t, func() bool { return repo.saves.Load() == 2 })
+
+	// activating the room again loads what was saved
+	again := dial(t, srv, "Alice")
+	frame := readUntil(t, again, "ROOM_SNAPSHOT")
+	if topics := frame["room"].(map[string]interface{})["topics"].(map[string]interface{}); len(topics) != 3 {
+		t.Errorf("Expected 3 topics after reactivation, got %d", len(topics))
+	}
+}
+
+func TestShouldSaveRoomsOnShutdown(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomFlushInterval = time.Hour
+	h, repo, roomId := newCountingHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+	addTopics(t, ws, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := repo.FindRoom(roomId)
+	if repo.saves.Load() != 2 || len(saved.Topics) != 3 {
+		t.Errorf("Room not saved on shutdown, %d saves", repo.saves.Load())
+	}
+}
+
+func TestShouldSaveCommandsAfterJoinWasSaved(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomFlushInterval = 50 * time.Millisecond
+	// the client doesn't read while waiting, so it doesn't answer pings
+	cfg.PongTimeout = 2 * time.Second
+	h, repo, roomId := newCountingHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+	// the join is saved on its own
+	waitFor(t, func() bool { return repo.saves.Load() == 2 })
+	time.Sleep(2 * cfg.RoomFlushInterval)
+
+	addTopics(t, ws, 2)
+	ws.Close()
+
+	waitFor(t, func() bool {
+		saved, err := repo.FindRoom(roomId)
+		return err == nil && saved != nil && len(saved.Topics) == 2
+	})
+}
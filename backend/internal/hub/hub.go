@@ -6,18 +6,28 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/oklog/ulid/v2"
 	"log"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/config"
 	"planning-poker/internal/room"
 	"planning-poker/internal/user"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
 
+// maxSaveAttempts bounds the saves of a room going through conflicts with other instances saving it
+const maxSaveAttempts = 3
+
 type ConnectWSResponse struct {
-	Type     string `json:"type"`
-	UserID   string `json:"user_id"`
-	UserName string `json:"user_name"`
-	RoomID   string `json:"room_id"`
+	Type        string    `json:"type"`
+	UserID      string    `json:"user_id"`
+	UserName    string    `json:"user_name"`
+	RoomID      string    `json:"room_id"`
+	Role        user.Role `json:"role"`
+	ResumeToken string    `json:"resume_token"`
+	Resumed     bool      `json:"resumed"`
+	Epoch       string    `json:"epoch"`
 }
 
 type FindRoomResponse struct {
@@ -25,156 +35,392 @@ type FindRoomResponse struct {
 	ConnectedUsers []user.User `json:"connected_users"`
 }
 
+type AckResponse struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type ErrorResponse struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
 type IncMessage struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id"`
+	Data      json.RawMessage `json:"data"`
 }
 
 type OutMessage struct {
 	Type    string
+	Seq     uint64
 	Payload interface{}
 }
 
+// RoomSnapshotResponse is the full state of a room as of the event Seq, so clients can apply the deltas that follow
+type RoomSnapshotResponse struct {
+	Type           string          `json:"type"`
+	Epoch          string          `json:"epoch"`
+	Seq            uint64          `json:"seq"`
+	Room           json.RawMessage `json:"room"`
+	ConnectedUsers []ConnectedUser `json:"connected_users"`
+}
+
+// StreamPosition is the last event a reconnecting client saw, Epoch names the event stream Seq belongs to
+type StreamPosition struct {
+	Epoch string
+	Seq   uint64
+}
+
+type ConnectedUser struct {
+	UserID user.UserID `json:"user_id"`
+	Name   string      `json:"name"`
+	Role   user.Role   `json:"role"`
+}
+
 type ActiveRoom struct {
+	RoomID         room.RoomID
 	Room           *room.Room
 	ConnectedUsers map[user.UserID]*UserConnection
-	CloseChan      chan bool
+	PendingLeaves  map[user.UserID]*PendingLeave
+	// Members are the users present in the room through any instance
+	Members   map[user.UserID]member
+	CloseChan chan struct{}
+
+	sub     backplane.Subscription
+	ready   chan struct{}
+	err     error
+	results map[string]chan error
+	joins   map[string]*pendingJoin
+	holds   int
+
+	writeBehind writeBehind
 }
 
-type UserConnection struct {
-	User user.User
-	Conn *websocket.Conn
-	Room *room.Room
+// PendingLeave is a user that dropped its connection and can still resume its session before the timer fires
+type PendingLeave struct {
+	User  user.User
+	Timer *time.Timer
 }
 
 type Hub struct {
 	ActiveRooms map[room.RoomID]*ActiveRoom
 
-	repo room.RoomRepo
-	Mu   sync.Mutex
+	repo              room.RoomRepo
+	backplane         backplane.Backplane
+	node              string
+	syncTimeout       time.Duration
+	tokens            user.TokenSigner
+	resumeGracePeriod time.Duration
+	connCfg           ConnectionConfig
+	retention         RetentionPolicy
+	flushInterval     time.Duration
+	flushMetrics      *flushMetrics
+	closing           bool
+	Mu                sync.Mutex
+
+	// deactivated rooms whose last changes are being saved
+	flushes map[room.RoomID]chan struct{}
+	// rooms being archived or deleted by the janitor
+	expiring map[room.RoomID]chan struct{}
 }
 
-func NewHub(roomRepo room.RoomRepo) Hub {
+func NewHub(cfg config.AppConfig, roomRepo room.RoomRepo, bp backplane.Backplane) Hub {
 	return Hub{
-		ActiveRooms: make(map[room.RoomID]*ActiveRoom),
-		repo:        roomRepo,
-		Mu:          sync.Mutex{},
+		ActiveRooms:       make(map[room.RoomID]*ActiveRoom),
+		repo:              roomRepo,
+		backplane:         bp,
+		node:              ulid.Make().String(),
+		syncTimeout:       cfg.SyncTimeout,
+		tokens:            user.NewTokenSigner(cfg.SessionSecret, cfg.ResumeTokenTTL),
+		resumeGracePeriod: cfg.ResumeGracePeriod,
+		connCfg: ConnectionConfig{
+			SendQueueSize: cfg.SendQueueSize,
+			PingInterval:  cfg.PingInterval,
+			PongTimeout:   cfg.PongTimeout,
+			WriteTimeout:  cfg.WriteTimeout,
+		},
+		retention: RetentionPolicy{
+			ArchiveAfter: cfg.RoomArchiveAfter,
+			DeleteAfter:  cfg.RoomDeleteAfter,
+			Interval:     cfg.JanitorInterval,
+		},
+		flushInterval: cfg.RoomFlushInterval,
+		flushMetrics:  &flushMetrics{},
+		flushes:       make(map[room.RoomID]chan struct{}),
+		expiring:      make(map[room.RoomID]chan struct{}),
+		Mu:            sync.Mutex{},
 	}
 }
 
-func (hub *Hub) CreateRoom() (*room.Room, error) {
+// CreateRoom creates a new room voting with the given deck, when a creator is given it joins as
+// the room's facilitator and gets a resume token to connect with that identity
+func (hub *Hub) CreateRoom(creator *user.User, deck room.Deck) (*room.Room, string, error) {
 	r := room.NewRoom(ulid.Make(), make(map[room.TopicID]*room.Topic), time.Now())
+	r.Deck = deck
+
+	token := ""
+	if creator != nil {
+		creator.Role = r.Join(*creator)
+
+		var err error
+		token, err = hub.tokens.Sign(*creator, r.RoomID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
 	err := hub.repo.Save(&r)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return &r, nil
+	return &r, token, nil
 }
 
-func (hub *Hub) ConnectToRoom(ws *websocket.Conn, u user.User, roomId room.RoomID) error {
-	hub.Mu.Lock()
-	defer hub.Mu.Unlock()
+// ResumeUser returns the user a resume token was issued to
+func (hub *Hub) ResumeUser(token string, roomId room.RoomID) (user.User, error) {
+	return hub.tokens.Verify(token, roomId)
+}
 
-	activeRoom, ok := hub.ActiveRooms[roomId]
+// ConnectToRoom joins the user to the room and sends them a snapshot of it after AUTH. Clients reconnecting
+// pass the last event they saw in seen, and get the events they missed instead as long as the room still
+// has them.
+func (hub *Hub) ConnectToRoom(ws *websocket.Conn, u user.User, roomId room.RoomID, seen *StreamPosition) error {
+	hub.Mu.Lock()
+	closing := hub.closing
+	hub.Mu.Unlock()
+	if closing {
+		ws.Close()
+		return ErrShuttingDown
+	}
 
-	// Room is inactive
-	if !ok {
-		r, err := hub.repo.FindRoom(roomId)
+	activeRoom, err := hub.acquireRoom(roomId)
+	if err != nil {
+		ws.Close()
+		return err
+	}
+	defer hub.releaseRoom(activeRoom)
 
-		if r == nil || err != nil {
-			return errors.New("room not found")
-		}
+	msg := hub.newMessage(msgJoin)
+	msg.User = u
 
-		activeRoom = &ActiveRoom{
-			Room:           r,
-			ConnectedUsers: make(map[user.UserID]*UserConnection),
-			CloseChan:      make(chan bool),
-		}
+	// the user is connected once every instance agrees on its role
+	join := &pendingJoin{ws: ws, seen: seen}
+	err = hub.publishAndWait(activeRoom, msg, join)
+	if err != nil {
+		ws.Close()
+		return err
+	}
 
-		hub.ActiveRooms[roomId] = activeRoom
+	return nil
+}
 
-		go hub.HandleRoomBroadcast(activeRoom)
-		log.Printf("Room %s enabled after first user", roomId)
+// connectUser opens the connection of a user whose JOIN was applied, must be called with hub.Mu held
+func (hub *Hub) connectUser(activeRoom *ActiveRoom, join *pendingJoin, u user.User, resumed bool) error {
+	// the shutdown already went through the connections of this room
+	if hub.closing {
+		return ErrShuttingDown
 	}
 
-	userConn := &UserConnection{
-		Conn: ws,
-		User: u,
-		Room: activeRoom.Room,
-	}
+	hub.markDirty(activeRoom)
 
-	activeRoom.ConnectedUsers[userConn.User.UserID] = userConn
+	token, err := hub.tokens.Sign(u, activeRoom.RoomID)
+	if err != nil {
+		return err
+	}
 
-	err := ws.WriteJSON(ConnectWSResponse{
-		Type:     "AUTH",
-		UserID:   u.UserID.String(),
-		UserName: u.Name,
-		RoomID:   roomId.String(),
+	userConn := NewUserConnection(join.ws, u, activeRoom.Room, hub.connCfg)
+	go userConn.writePump()
+
+	userConn.Send(ConnectWSResponse{
+		Type:        "AUTH",
+		UserID:      u.UserID.String(),
+		UserName:    u.Name,
+		RoomID:      activeRoom.RoomID.String(),
+		Role:        u.Role,
+		ResumeToken: token,
+		Resumed:     resumed,
+		Epoch:       activeRoom.Room.Epoch(),
 	})
 
+	err = hub.syncClient(activeRoom, userConn, join.seen)
 	if err != nil {
-		ws.Close()
+		userConn.Close(websocket.CloseInternalServerErr, "")
 		return err
 	}
 
-	activeRoom.Room.BroadcastEvent(room.UserJoinedRoom{
-		UserID:   userConn.User.UserID,
-		Username: userConn.User.Name,
-	})
+	// the snapshot or replayed events must be queued before the broadcaster sees the connection,
+	// so the hub lock is held until it is registered
+	activeRoom.ConnectedUsers[userConn.User.UserID] = userConn
 
 	go hub.ListenClientCommands(userConn)
 
-	log.Printf("User %s connected to Room ID: %s\n", userConn.User.Name, roomId.String())
+	log.Printf("User %s connected to Room ID: %s\n", userConn.User.Name, activeRoom.RoomID.String())
 
 	return nil
 }
 
 func (hub *Hub) DisconnectFromRoom(userConn *UserConnection, roomId room.RoomID) {
-	hub.Mu.Lock()
-	defer hub.Mu.Unlock()
+	userConn.Close(websocket.CloseNormalClosure, "")
 
-	_, ok := hub.ActiveRooms[roomId]
-	if !ok {
+	hub.Mu.Lock()
+	activeRoom, ok := hub.ActiveRooms[roomId]
+	// a newer connection of the same user already took over
+	if !ok || activeRoom.ConnectedUsers[userConn.User.UserID] != userConn {
+		hub.Mu.Unlock()
 		return
 	}
 
-	delete(hub.ActiveRooms[roomId].ConnectedUsers, userConn.User.UserID)
+	delete(activeRoom.ConnectedUsers, userConn.User.UserID)
 
-	hub.ActiveRooms[roomId].Room.BroadcastEvent(room.UserLeftRoom{UserID: userConn.User.UserID})
+	// shutting down already announced the user left
+	if hub.closing {
+		hub.Mu.Unlock()
+		return
+	}
 
 	log.Printf("User %s disconnected from Room ID: %s\n", userConn.User.Name, roomId.String())
 
-	// disable room if no connected users left
-	if len(hub.ActiveRooms[roomId].ConnectedUsers) == 0 {
-		hub.ActiveRooms[roomId].CloseChan <- true
-		delete(hub.ActiveRooms, roomId)
-		log.Printf("Room %s disabled due to inactivity\n", roomId.String())
+	if hub.resumeGracePeriod <= 0 {
+		leave := hub.leaveRoom(activeRoom, userConn.User)
+		hub.Mu.Unlock()
+
+		hub.publishLeave(leave)
+		return
 	}
+
+	pending := &PendingLeave{User: userConn.User}
+	pending.Timer = time.AfterFunc(hub.resumeGracePeriod, func() {
+		hub.expirePendingLeave(roomId, pending)
+	})
+	activeRoom.PendingLeaves[userConn.User.UserID] = pending
+	hub.Mu.Unlock()
 }
 
-func (hub *Hub) FindRoom(roomId room.RoomID) (*FindRoomResponse, error) {
-	r, err := hub.repo.FindRoom(roomId)
+// syncClient brings a new client up to date after AUTH. A reconnecting client gets the events it missed
+// after the last one it saw when they are still buffered, and the room's event stream is still the one
+// it saw them in. Anyone else gets a snapshot of the room. Events up to that point still waiting to be
+// broadcast are skipped for this client. Must be called with the hub lock held.
+func (hub *Hub) syncClient(activeRoom *ActiveRoom, userConn *UserConnection, seen *StreamPosition) error {
+	if seen != nil && seen.Epoch == activeRoom.Room.Epoch() {
+		if events, ok := activeRoom.Room.EventsSince(seen.Seq); ok {
+			userConn.syncedSeq.Store(seen.Seq)
+			for _, env := range events {
+				userConn.Send(outMessage(env))
+				userConn.syncedSeq.Store(env.Seq)
+			}
+			return nil
+		}
+	}
+
+	snapshot, err := activeRoom.Room.Snapshot()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if r == nil {
-		return nil, nil
+	userConn.Send(RoomSnapshotResponse{
+		Type:           "ROOM_SNAPSHOT",
+		Epoch:          snapshot.Lineage.String(),
+		Seq:            snapshot.Seq,
+		Room:           snapshot.Room,
+		ConnectedUsers: connectedUsersOf(activeRoom),
+	})
+	userConn.syncedSeq.Store(snapshot.Seq)
+
+	return nil
+}
+
+// connectedUsersOf lists the users present in the room through any instance, counting those inside their resume grace period
+func connectedUsersOf(activeRoom *ActiveRoom) []ConnectedUser {
+	users := make([]ConnectedUser, 0, len(activeRoom.Members))
+	for _, m := range activeRoom.Members {
+		users = append(users, ConnectedUser{
+			UserID: m.User.UserID,
+			Name:   m.User.Name,
+			Role:   m.User.Role,
+		})
 	}
 
-	// don't know if this is necessary here
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID.Compare(users[j].UserID) < 0
+	})
+
+	return users
+}
+
+// expirePendingLeave runs when a disconnected user didn't resume its session within the grace period
+func (hub *Hub) expirePendingLeave(roomId room.RoomID, pending *PendingLeave) {
 	hub.Mu.Lock()
-	defer hub.Mu.Unlock()
+	activeRoom, ok := hub.ActiveRooms[roomId]
+	if !ok || activeRoom.PendingLeaves[pending.User.UserID] != pending {
+		hub.Mu.Unlock()
+		return
+	}
+
+	delete(activeRoom.PendingLeaves, pending.User.UserID)
+	leave := hub.leaveRoom(activeRoom, pending.User)
+	hub.Mu.Unlock()
+
+	hub.publishLeave(leave)
+}
+
+// roomLeave is the announcement a user left a room
+type roomLeave struct {
+	roomId room.RoomID
+	msg    roomMessage
+}
 
+// leaveRoom disables the room when nobody is left here and returns the announcement the user left, to publish
+// with publishLeave once hub.Mu is released so a slow backplane doesn't hold up every room. Must be called with
+// hub.Mu held.
+func (hub *Hub) leaveRoom(activeRoom *ActiveRoom, u user.User) roomLeave {
+	msg := hub.newMessage(msgLeave)
+	msg.User = u
+
+	hub.deactivateIfIdle(activeRoom)
+
+	return roomLeave{roomId: activeRoom.RoomID, msg: msg}
+}
+
+func (hub *Hub) publishLeave(leave roomLeave) {
+	err := hub.publish(leave.roomId, leave.msg)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (hub *Hub) FindRoom(roomId room.RoomID) (*FindRoomResponse, error) {
+	hub.Mu.Lock()
+	var active *room.Room
+	// users inside the resume grace period are still shown as connected
 	var connectedUsers []user.User
-	_, ok := hub.ActiveRooms[roomId]
-	if ok {
-		for _, u := range hub.ActiveRooms[roomId].ConnectedUsers {
-			connectedUsers = append(connectedUsers, u.User)
+	if activeRoom, ok := hub.ActiveRooms[roomId]; ok {
+		active = activeRoom.Room
+		for _, m := range activeRoom.Members {
+			connectedUsers = append(connectedUsers, m.User)
 		}
 	}
+	hub.Mu.Unlock()
+
+	// the latest changes of an active room may not be saved yet
+	var r *room.Room
+	var err error
+	if active != nil {
+		r, err = copyRoom(active)
+	} else {
+		hub.waitFlushed(roomId)
+		r, err = hub.repo.FindRoom(roomId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, nil
+	}
 
 	return &FindRoomResponse{
 		Room:           r,
@@ -182,6 +428,69 @@ func (hub *Hub) FindRoom(roomId room.RoomID) (*FindRoomResponse, error) {
 	}, nil
 }
 
+// ImportTopics adds every draft to the room on behalf of the user holding the resume token,
+// going through the active room so the import reaches every instance serving it
+func (hub *Hub) ImportTopics(roomId room.RoomID, resumeToken string, drafts []room.TopicDraft) ([]room.TopicID, error) {
+	u, err := hub.tokens.Verify(resumeToken, roomId)
+	if err != nil {
+		return nil, ErrPermissionDenied
+	}
+
+	activeRoom, err := hub.acquireRoom(roomId)
+	if err != nil {
+		return nil, err
+	}
+	defer hub.releaseRoom(activeRoom)
+
+	msg := hub.newMessage(msgImportTopics)
+	msg.User = u
+
+	topicIds := make([]room.TopicID, 0, len(drafts))
+	for _, d := range drafts {
+		topicId := ulid.Make()
+		topicIds = append(topicIds, topicId)
+		msg.Drafts = append(msg.Drafts, importDraft{
+			TopicID:     topicId,
+			Title:       d.Title,
+			Url:         d.Url,
+			Description: d.Description,
+		})
+	}
+
+	err = hub.publishAndWait(activeRoom, msg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// saved right away, so conflicts are reported to the caller
+	hub.markDirty(activeRoom)
+	err = hub.flushRoom(activeRoom)
+	if err != nil {
+		return nil, err
+	}
+
+	return topicIds, nil
+}
+
+// saveRoom saves the room, moving it onto the version saved by another instance serving it when they
+// conflict. Conflicts with anyone else, like a tool writing to the store, are returned and the stored
+// room is kept as it is.
+func (hub *Hub) saveRoom(r *room.Room) error {
+	for attempt := 1; ; attempt++ {
+		err := hub.repo.Save(r)
+
+		var conflict *room.ConflictError
+		if !errors.As(err, &conflict) || attempt == maxSaveAttempts {
+			return err
+		}
+
+		upToDate, err := r.Rebase(conflict)
+		if err != nil || upToDate {
+			return err
+		}
+	}
+}
+
 // ListenClientCommands is a goroutine running for each connected client
 func (hub *Hub) ListenClientCommands(userConn *UserConnection) {
 	r := userConn.Room
@@ -190,91 +499,259 @@ func (hub *Hub) ListenClientCommands(userConn *UserConnection) {
 		hub.DisconnectFromRoom(userConn, r.RoomID)
 	}()
 
+	userConn.prepareRead()
+
 	for {
-		var m IncMessage
-		err := userConn.Conn.ReadJSON(&m)
+		data, err := userConn.ReadMessage()
 		if err != nil {
 			return
 		}
 
-		switch m.Type {
-		case "ADD_TOPIC":
-			var cmd AddTopicCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.AddTopic(ulid.Make(), cmd.Title, cmd.URL, cmd.Content)
-			}
-		case "REMOVE_TOPIC":
-			var cmd RemoveTopicCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.RemoveTopic(cmd.TopicID)
-			}
-		case "COMPLETE_TOPIC":
-			var cmd CompleteTopicCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.CompleteTopic(cmd.TopicID, cmd.Points)
-			}
-		case "RESET_TOPIC":
-			var cmd ResetTopicVotesCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.ResetTopic(cmd.TopicID)
-			}
-		case "VOTE_ON_TOPIC":
-			var cmd VoteOnTopicCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.VoteOnTopic(userConn.User.UserID, cmd.TopicID, cmd.Points)
-			}
-		case "CHANGE_CURRENT_TOPIC":
-			var cmd ChangeCurrentTopicCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.SetCurrentTopic(cmd.TopicID)
-			}
-		case "ADD_COMENT":
-			var cmd AddCommentCommand
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.AddComment(ulid.Make(), cmd.TopicID, cmd.Content)
-			}
-		case "TOGGLE_VISIBILITY":
-			var cmd ToggleVisibility
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.ToggleVisibility(cmd.TopicID)
-			}
-		case "CHANGE_TOPIC_DETAILS":
-			var cmd ChangeTopicDetails
-			err := json.Unmarshal(m.Data, &cmd)
-			if err == nil {
-				r.ChangeTopicDetails(cmd.TopicID, cmd.Title, cmd.Desc, cmd.Url)
-			}
+		var m IncMessage
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			err = malformedPayload(err)
+		} else {
+			err = hub.sendCommand(userConn, m)
 		}
 
-		err = hub.repo.Save(r)
 		if err != nil {
-			log.Println(err)
+			cmdErr := toCommandError(err)
+			userConn.Send(ErrorResponse{
+				Type:      "ERROR",
+				RequestID: m.RequestID,
+				Code:      cmdErr.Code,
+				Message:   cmdErr.Message,
+			})
+			continue
+		}
+
+		userConn.Send(AckResponse{
+			Type:      "ACK",
+			RequestID: m.RequestID,
+		})
+	}
+}
+
+// sendCommand publishes a client message to every instance serving the room, and waits until it is applied here
+func (hub *Hub) sendCommand(userConn *UserConnection, m IncMessage) error {
+	hub.Mu.Lock()
+	activeRoom, ok := hub.ActiveRooms[userConn.Room.RoomID]
+	hub.Mu.Unlock()
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	msg := hub.newMessage(msgCommand)
+	msg.User = userConn.User
+	msg.Command = &m
+	msg.NewID = ulid.Make()
+
+	return hub.publishAndWait(activeRoom, msg, nil)
+}
+
+// applyCommand decodes a single client message and applies it to the room
+func (hub *Hub) applyCommand(r *room.Room, msg roomMessage) error {
+	m := *msg.Command
+	userId := msg.User.UserID
+
+	err := checkPermission(r.UserRole(userId), m.Type)
+	if err != nil {
+		return err
+	}
+
+	switch m.Type {
+	case "ADD_TOPIC":
+		var cmd AddTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		r.AddTopic(msg.NewID, cmd.Title, cmd.URL, cmd.Content)
+		return nil
+	case "REMOVE_TOPIC":
+		var cmd RemoveTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.RemoveTopic(cmd.TopicID)
+	case "COMPLETE_TOPIC":
+		var cmd CompleteTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.CompleteTopic(cmd.TopicID, cmd.Points)
+	case "RESET_TOPIC":
+		var cmd ResetTopicVotesCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.ResetTopic(cmd.TopicID, cmd.Reason)
+	case "VOTE_ON_TOPIC":
+		var cmd VoteOnTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.VoteOnTopic(userId, cmd.TopicID, cmd.Points)
+	case "CHANGE_CURRENT_TOPIC":
+		var cmd ChangeCurrentTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.SetCurrentTopic(cmd.TopicID)
+	case "ADD_COMENT":
+		var cmd AddCommentCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.AddComment(msg.NewID, cmd.TopicID, cmd.Content)
+	case "TOGGLE_VISIBILITY":
+		var cmd ToggleVisibility
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.ToggleVisibility(cmd.TopicID)
+	case "CHANGE_TOPIC_DETAILS":
+		var cmd ChangeTopicDetails
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.ChangeTopicDetails(cmd.TopicID, cmd.Title, cmd.Desc, cmd.Url)
+	case "SET_USER_ROLE":
+		var cmd SetUserRoleCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		if err := r.SetUserRole(cmd.UserID, cmd.Role); err != nil {
+			return err
+		}
+		hub.updateMemberRole(r.RoomID, cmd.UserID, cmd.Role)
+		return nil
+	case "CHANGE_DECK":
+		var cmd ChangeDeckCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		deck, err := room.NewDeck(cmd.Deck, cmd.Cards)
+		if err != nil {
+			return err
+		}
+		r.ChangeDeck(deck)
+		return nil
+	case "START_TIMER":
+		var cmd StartTimerCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.StartTimer(time.Duration(cmd.Seconds)*time.Second, cmd.AutoReveal)
+	case "PAUSE_TIMER":
+		return r.PauseTimer()
+	case "RESUME_TIMER":
+		return r.ResumeTimer()
+	case "EXTEND_TIMER":
+		var cmd ExtendTimerCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
 		}
+		return r.ExtendTimer(time.Duration(cmd.Seconds) * time.Second)
+	case "CANCEL_TIMER":
+		return r.CancelTimer()
+	case "REORDER_TOPICS":
+		var cmd ReorderTopicsCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.ReorderTopics(cmd.TopicIDs)
+	case "MOVE_TOPIC":
+		var cmd MoveTopicCommand
+		if err := decodeCommand(m.Data, &cmd); err != nil {
+			return err
+		}
+		return r.MoveTopic(cmd.TopicID, cmd.Position)
+	case "NEXT_TOPIC":
+		_, err := r.NextTopic()
+		return err
 	}
+
+	return ErrUnknownCommand
 }
 
-// HandleRoomBroadcast is a goroutine for each active room to dispatch messages for every user in the room
+// updateMemberRole keeps the role of a member in sync after it was changed in the room. Connections keep
+// the role they were opened with, permissions are checked against the room.
+func (hub *Hub) updateMemberRole(roomId room.RoomID, userId user.UserID, role user.Role) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	activeRoom, ok := hub.ActiveRooms[roomId]
+	if !ok {
+		return
+	}
+
+	if m, ok := activeRoom.Members[userId]; ok {
+		m.User.Role = role
+		activeRoom.Members[userId] = m
+	}
+}
+
+func decodeCommand(data json.RawMessage, cmd interface{}) error {
+	err := json.Unmarshal(data, cmd)
+	if err != nil {
+		return malformedPayload(err)
+	}
+
+	return nil
+}
+
+// HandleRoomBroadcast is a goroutine for each active room to dispatch messages for every user in the room,
+// it also drives the room timer
 func (hub *Hub) HandleRoomBroadcast(activeRoom *ActiveRoom) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// the timer keeps expiring on every tick until the published expiry comes back, it is published once
+	// per deadline unless it doesn't come back in time
+	var expiring time.Time
+	var expiringSince time.Time
+
 	for {
 		select {
-		case m := <-activeRoom.Room.BroadcastChan:
-			for _, userConn := range activeRoom.ConnectedUsers {
-				outM := OutMessage{
-					Type:    reflect.TypeOf(m).Name(),
-					Payload: m,
+		case now := <-ticker.C:
+			// expiring goes through the backplane, so every instance reveals at the same point
+			expired := activeRoom.Room.TickTimer(now)
+			if expired == nil || expired.Deadline == nil {
+				continue
+			}
+			if expired.Deadline.Equal(expiring) && now.Sub(expiringSince) < messageTimeout {
+				continue
+			}
+
+			msg := hub.newMessage(msgTimerExpired)
+			msg.Timer = expired
+
+			err := hub.publish(activeRoom.RoomID, msg)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			expiring, expiringSince = *expired.Deadline, now
+		case env := <-activeRoom.Room.BroadcastChan:
+			conns := hub.roomConnections(activeRoom)
+
+			// queues never block, so a slow client can't hold up the rest of the room
+			for _, userConn := range conns {
+				if env.Seq == 0 {
+					userConn.Send(outMessage(env))
+				} else {
+					hub.sendEvent(activeRoom, userConn, env)
 				}
+			}
 
-				err := userConn.Conn.WriteJSON(outM)
-				if err != nil {
-					log.Printf("error writing to client: %v", err)
+			// the events dropped while the broadcaster was behind are only in the room's buffer
+			if len(activeRoom.Room.BroadcastChan) == 0 {
+				lastSeq := activeRoom.Room.LastSeq()
+				for _, userConn := range conns {
+					if userConn.syncedSeq.Load() < lastSeq {
+						hub.catchUp(activeRoom, userConn)
+					}
 				}
 			}
 		case <-activeRoom.CloseChan:
@@ -282,3 +759,73 @@ func (hub *Hub) HandleRoomBroadcast(activeRoom *ActiveRoom) {
 		}
 	}
 }
+
+// sendEvent sends a sequenced event to the client, after the events before it the client missed
+func (hub *Hub) sendEvent(activeRoom *ActiveRoom, userConn *UserConnection, env room.Envelope) {
+	synced := userConn.syncedSeq.Load()
+
+	// already replayed to a client that just caught up
+	if env.Seq <= synced {
+		return
+	}
+
+	if env.Seq > synced+1 {
+		hub.catchUp(activeRoom, userConn)
+		return
+	}
+
+	// a snapshot sent meanwhile already has the event
+	if userConn.syncedSeq.CompareAndSwap(synced, env.Seq) {
+		userConn.Send(outMessage(env))
+	}
+}
+
+// catchUp sends the client the events it missed from the room's buffer, or a snapshot when they are gone
+func (hub *Hub) catchUp(activeRoom *ActiveRoom, userConn *UserConnection) {
+	synced := userConn.syncedSeq.Load()
+
+	events, ok := activeRoom.Room.EventsSince(synced)
+	if ok {
+		for _, env := range events {
+			if !userConn.syncedSeq.CompareAndSwap(synced, env.Seq) {
+				return
+			}
+			userConn.Send(outMessage(env))
+			synced = env.Seq
+		}
+		return
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	if activeRoom.ConnectedUsers[userConn.User.UserID] != userConn {
+		return
+	}
+
+	err := hub.syncClient(activeRoom, userConn, nil)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func outMessage(env room.Envelope) OutMessage {
+	return OutMessage{
+		Type:    reflect.TypeOf(env.Event).Name(),
+		Seq:     env.Seq,
+		Payload: env.Event,
+	}
+}
+
+// roomConnections returns the connections of the room at this moment, so they can be written to without holding the hub lock
+func (hub *Hub) roomConnections(activeRoom *ActiveRoom) []*UserConnection {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	conns := make([]*UserConnection, 0, len(activeRoom.ConnectedUsers))
+	for _, userConn := range activeRoom.ConnectedUsers {
+		conns = append(conns, userConn)
+	}
+
+	return conns
+}
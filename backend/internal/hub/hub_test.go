@@ -0,0 +1,468 @@
+package hub
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/config"
+	"planning-poker/internal/room"
+	"planning-poker/internal/user"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig() config.AppConfig {
+	return config.AppConfig{
+		SessionSecret:  []byte("secret"),
+		ResumeTokenTTL: time.Hour,
+		SendQueueSize:  16,
+		PingInterval:   20 * time.Millisecond,
+		PongTimeout:    100 * time.Millisecond,
+		WriteTimeout:   100 * time.Millisecond,
+	}
+}
+
+// newTestServer serves the hub over websockets, connecting every client to roomId
+func newTestServer(t *testing.T, h *Hub, roomId room.RoomID) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		var seen *StreamPosition
+		if seq, err := strconv.ParseUint(r.URL.Query().Get("last_seq"), 10, 64); err == nil {
+			seen = &StreamPosition{Epoch: r.URL.Query().Get("epoch"), Seq: seq}
+		}
+
+		u := user.NewUser(ulid.Make(), r.URL.Query().Get("username"))
+		if resumed, err := h.ResumeUser(r.URL.Query().Get("resume_token"), roomId); err == nil {
+			u = resumed
+		}
+		if err := h.ConnectToRoom(ws, u, roomId, seen); err != nil {
+			ws.Close()
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, username string) *websocket.Conn {
+	return dialQuery(t, srv, url.Values{"username": {username}})
+}
+
+func dialQuery(t *testing.T, srv *httptest.Server, query url.Values) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?" + query.Encode()
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
+
+func newTestHub(t *testing.T, cfg config.AppConfig) (*Hub, room.RoomID) {
+	repo := room.NewRoomRepoMemory()
+	h := NewHub(cfg, &repo, backplane.NewMemory())
+
+	r, _, err := h.CreateRoom(nil, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &h, r.RoomID
+}
+
+func connectedUsers(h *Hub, roomId room.RoomID) int {
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	activeRoom, ok := h.ActiveRooms[roomId]
+	if !ok {
+		return 0
+	}
+
+	return len(activeRoom.ConnectedUsers)
+}
+
+// readFrame reads the next json frame sent to the client
+func readFrame(t *testing.T, ws *websocket.Conn) map[string]interface{} {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var frame map[string]interface{}
+	if err := ws.ReadJSON(&frame); err != nil {
+		t.Fatal(err)
+	}
+
+	return frame
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("Condition not met in time")
+}
+
+func TestShouldDisconnectDeadPeers(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	// this client never reads, so it never answers the server pings
+	dial(t, srv, "ghost")
+
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 0 })
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	if _, ok := h.ActiveRooms[roomId]; ok {
+		t.Error("Room still active after its only user died")
+	}
+}
+
+func TestShouldKeepResponsivePeers(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "alive")
+
+	// reading makes the client answer pings
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+	time.Sleep(300 * time.Millisecond)
+
+	if connectedUsers(h, roomId) != 1 {
+		t.Error("Disconnected a peer answering pings")
+	}
+}
+
+func TestShouldReplayMissedEventsOnReconnect(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	alice := dial(t, srv, "alice") // seq 1, alice joined
+	epoch := readFrame(t, alice)["epoch"].(string)
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+
+	h.Mu.Lock()
+	h.ActiveRooms[roomId].Room.AddTopic(ulid.Make(), "Missed topic", "", "") // seq 2
+	h.Mu.Unlock()
+
+	ws := dialQuery(t, srv, url.Values{"username": {"bob"}, "last_seq": {"1"}, "epoch": {epoch}})
+
+	if frame := readFrame(t, ws); frame["type"] != "AUTH" {
+		t.Fatalf("Expected AUTH, got %v", frame)
+	}
+	if frame := readFrame(t, ws); frame["Type"] != "TopicAddedEvent" || frame["Seq"] != float64(2) {
+		t.Fatalf("Expected missed TopicAddedEvent, got %v", frame)
+	}
+	if frame := readFrame(t, ws); frame["Type"] != "UserJoinedRoom" || frame["Seq"] != float64(3) {
+		t.Fatalf("Expected UserJoinedRoom, got %v", frame)
+	}
+}
+
+func TestShouldSendSnapshotWhenTooFarBehind(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	// the room was reloaded since, so the client's sequence number is from an older stream
+	ws := dialQuery(t, srv, url.Values{"username": {"bob"}, "last_seq": {"1000"}})
+
+	if frame := readFrame(t, ws); frame["type"] != "AUTH" {
+		t.Fatalf("Expected AUTH, got %v", frame)
+	}
+	frame := readFrame(t, ws)
+	if frame["type"] != "ROOM_SNAPSHOT" || frame["seq"] != float64(0) {
+		t.Fatalf("Expected ROOM_SNAPSHOT, got %v", frame)
+	}
+	if _, ok := frame["room"].(map[string]interface{}); !ok {
+		t.Error("Snapshot misses the room")
+	}
+}
+
+func TestShouldSendSnapshotForOtherStream(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	alice := dial(t, srv, "alice")
+	epoch := readFrame(t, alice)["epoch"].(string)
+	alice.Close()
+
+	// loaded again, the room's events are numbered from the start
+	waitFor(t, func() bool {
+		h.Mu.Lock()
+		defer h.Mu.Unlock()
+		return len(h.ActiveRooms) == 0 && len(h.flushes) == 0
+	})
+
+	ws := dialQuery(t, srv, url.Values{"username": {"alice"}, "last_seq": {"0"}, "epoch": {epoch}})
+
+	auth := readFrame(t, ws)
+	if auth["type"] != "AUTH" || auth["epoch"] == epoch {
+		t.Fatalf("Expected AUTH in another stream, got %v", auth)
+	}
+	if frame := readFrame(t, ws); frame["type"] != "ROOM_SNAPSHOT" || frame["epoch"] != auth["epoch"] {
+		t.Fatalf("Expected ROOM_SNAPSHOT, got %v", frame)
+	}
+}
+
+func TestShouldSendSnapshotAfterAuth(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	alice := dial(t, srv, "alice")
+	readFrame(t, alice) // AUTH
+	readFrame(t, alice) // ROOM_SNAPSHOT
+
+	h.Mu.Lock()
+	h.ActiveRooms[roomId].Room.AddTopic(ulid.Make(), "Existing topic", "", "")
+	h.Mu.Unlock()
+
+	ws := dial(t, srv, "bob")
+
+	if frame := readFrame(t, ws); frame["type"] != "AUTH" {
+		t.Fatalf("Expected AUTH, got %v", frame)
+	}
+
+	frame := readFrame(t, ws)
+	if frame["type"] != "ROOM_SNAPSHOT" {
+		t.Fatalf("Expected ROOM_SNAPSHOT, got %v", frame)
+	}
+	if frame["seq"] != float64(2) {
+		t.Errorf("Expected snapshot at seq 2, got %v", frame["seq"])
+	}
+	if topics := frame["room"].(map[string]interface{})["topics"].(map[string]interface{}); len(topics) != 1 {
+		t.Errorf("Expected 1 topic in snapshot, got %d", len(topics))
+	}
+	users := frame["connected_users"].([]interface{})
+	if len(users) != 1 || users[0].(map[string]interface{})["name"] != "alice" {
+		t.Errorf("Expected alice connected, got %v", users)
+	}
+
+	// the deltas pick up right after the snapshot
+	if frame := readFrame(t, ws); frame["Type"] != "UserJoinedRoom" || frame["Seq"] != float64(3) {
+		t.Fatalf("Expected UserJoinedRoom at seq 3, got %v", frame)
+	}
+}
+
+func TestShouldNotifyClientsOnShutdown(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "alice")
+	readFrame(t, ws) // AUTH
+	readFrame(t, ws) // ROOM_SNAPSHOT
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := readUntil(t, ws, "SERVER_RESTARTING")
+	if delay := frame["reconnect_after_ms"].(float64); delay < 1000 || delay > 5000 {
+		t.Errorf("Unexpected reconnect hint %v", delay)
+	}
+
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected a service restart close, got %v", err)
+	}
+
+	// new connections are refused
+	late := dial(t, srv, "bob")
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := late.ReadMessage(); err == nil || websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected the connection to be dropped, got %v", err)
+	}
+}
+
+func TestShouldReportConflictingSave(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomFlushInterval = 50 * time.Millisecond
+	h, roomId := newTestHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readFrame(t, ws)
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+
+	// a tool loads the room on its own and saves a change
+	h.Mu.Lock()
+	snapshot, err := h.ActiveRooms[roomId].Room.Snapshot()
+	h.Mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Lineage = ulid.Make()
+	other, _ := room.FromSnapshot(snapshot)
+	other.AddTopic(ulid.Make(), "Added elsewhere", "", "")
+	if err := h.repo.Save(other); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.WriteJSON(map[string]interface{}{
+		"type":       "ADD_TOPIC",
+		"request_id": "1",
+		"data":       map[string]string{"title": "Added here"},
+	})
+
+	// the change is applied right away, the conflict only shows once the room is saved
+	readUntil(t, ws, "ACK")
+	if frame := readUntil(t, ws, "ERROR"); frame["code"] != ErrCodeSaveConflict || frame["request_id"] != nil {
+		t.Errorf("Unexpected error: %v", frame)
+	}
+
+	saved, _ := h.repo.FindRoom(roomId)
+	if topics := saved.OrderedTopics(); len(topics) != 1 || topics[0].Title != "Added elsewhere" {
+		t.Error("Room saved by the tool was overwritten")
+	}
+
+	// the room is replaced with the saved one, and takes changes that can be saved again
+	frame := readUntil(t, ws, "ROOM_SNAPSHOT")
+	if topics := frame["room"].(map[string]interface{})["topics"].(map[string]interface{}); len(topics) != 1 {
+		t.Errorf("Expected the saved room sent, got %d topics", len(topics))
+	}
+
+	ws.WriteJSON(map[string]interface{}{
+		"type":       "ADD_TOPIC",
+		"request_id": "2",
+		"data":       map[string]string{"title": "Added after reload"},
+	})
+	readUntil(t, ws, "ACK")
+
+	waitFor(t, func() bool {
+		saved, err := h.repo.FindRoom(roomId)
+		return err == nil && len(saved.Topics) == 2
+	})
+}
+
+func TestShouldReportFailingCommands(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	// the first user joins as facilitator, so every command is allowed
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+
+	tests := []struct {
+		name    string
+		command map[string]interface{}
+		code    string
+	}{
+		{
+			name:    "unknown command",
+			command: map[string]interface{}{"type": "ORDER_PIZZA"},
+			code:    ErrCodeUnknownCommand,
+		},
+		{
+			name:    "malformed payload",
+			command: map[string]interface{}{"type": "REMOVE_TOPIC", "data": map[string]string{"topic_id": "not a ulid"}},
+			code:    ErrCodeMalformedPayload,
+		},
+		{
+			name:    "missing topic",
+			command: map[string]interface{}{"type": "REMOVE_TOPIC", "data": map[string]string{"topic_id": ulid.Make().String()}},
+			code:    ErrCodeTopicNotFound,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requestId := strconv.Itoa(i + 1)
+			test.command["request_id"] = requestId
+			ws.WriteJSON(test.command)
+
+			frame := readUntil(t, ws, "ERROR")
+			if frame["code"] != test.code || frame["request_id"] != requestId {
+				t.Errorf("Expected %s for request %s, got %v", test.code, requestId, frame)
+			}
+		})
+	}
+}
+
+func TestShouldReportMalformedFrame(t *testing.T) {
+	h, roomId := newTestHub(t, testConfig())
+	srv := newTestServer(t, h, roomId)
+
+	ws := dial(t, srv, "Alice")
+	readUntil(t, ws, "UserJoinedRoom")
+
+	ws.WriteMessage(websocket.TextMessage, []byte("{not json"))
+
+	if frame := readUntil(t, ws, "ERROR"); frame["code"] != ErrCodeMalformedPayload {
+		t.Errorf("Unexpected error: %v", frame)
+	}
+}
+
+func TestShouldResumeSessionWithinGracePeriod(t *testing.T) {
+	cfg := testConfig()
+	cfg.ResumeGracePeriod = 300 * time.Millisecond
+	// clients only answer pings while reading, and alice waits past the grace period
+	cfg.PongTimeout = 2 * time.Second
+	h, roomId := newTestHub(t, cfg)
+	srv := newTestServer(t, h, roomId)
+
+	alice := dial(t, srv, "alice")
+	readUntil(t, alice, "UserJoinedRoom")
+
+	bob := dial(t, srv, "bob")
+	auth := readUntil(t, bob, "AUTH")
+	readUntil(t, alice, "UserJoinedRoom")
+
+	bob.Close()
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+
+	resumed := dialQuery(t, srv, url.Values{"resume_token": {auth["resume_token"].(string)}})
+	frame := readUntil(t, resumed, "AUTH")
+	if frame["user_id"] != auth["user_id"] || frame["user_name"] != "bob" || frame["resumed"] != true {
+		t.Fatalf("Session not resumed: %v", frame)
+	}
+
+	// the leave of the first connection must not fire once the grace period is over
+	time.Sleep(2 * cfg.ResumeGracePeriod)
+	resumed.WriteJSON(map[string]interface{}{
+		"type":       "ADD_TOPIC",
+		"request_id": "1",
+		"data":       map[string]string{"title": "Marker"},
+	})
+	for {
+		frame := readFrame(t, alice)
+		if frame["Type"] == "UserLeftRoom" {
+			t.Fatal("Resumed user announced as left")
+		}
+		if frame["Type"] == "TopicAddedEvent" {
+			break
+		}
+	}
+
+	resumed.Close()
+	frame = readUntil(t, alice, "UserLeftRoom")
+	if payload := frame["Payload"].(map[string]interface{}); payload["client_id"] != auth["user_id"] {
+		t.Errorf("Wrong user left: %v", frame)
+	}
+}
@@ -0,0 +1,158 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"planning-poker/internal/room"
+	"time"
+)
+
+// RetentionPolicy tells how long inactive rooms are kept. Rooms nobody changed for ArchiveAfter are
+// archived, and archived rooms are deleted after DeleteAfter. Zero turns the step off.
+type RetentionPolicy struct {
+	ArchiveAfter time.Duration
+	DeleteAfter  time.Duration
+	Interval     time.Duration
+}
+
+// RunJanitor applies the retention policy every interval until ctx is done
+func (hub *Hub) RunJanitor(ctx context.Context) {
+	if hub.retention.ArchiveAfter == 0 {
+		log.Println("Room archiving is off, the janitor won't run")
+		return
+	}
+
+	ticker := time.NewTicker(hub.retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := hub.sweepRooms(time.Now())
+		if err != nil {
+			log.Println("Janitor:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepRooms archives the rooms inactive for too long and deletes the ones archived for too long.
+// Rooms active on any instance are skipped.
+func (hub *Hub) sweepRooms(now time.Time) error {
+	activities, err := hub.repo.ListRoomActivity()
+	if err != nil {
+		return err
+	}
+
+	archived, deleted := 0, 0
+	for _, activity := range activities {
+		if !hub.retention.expired(activity, now) {
+			continue
+		}
+
+		done, err := hub.expireRoom(activity.RoomID, now)
+		if err != nil {
+			log.Printf("Janitor: room %s: %v", activity.RoomID, err)
+			continue
+		}
+
+		switch done {
+		case roomArchived:
+			archived++
+		case roomDeleted:
+			deleted++
+		}
+	}
+
+	if archived > 0 || deleted > 0 {
+		log.Printf("Janitor archived %d and deleted %d rooms", archived, deleted)
+	}
+
+	return nil
+}
+
+type expiry int
+
+const (
+	roomKept expiry = iota
+	roomArchived
+	roomDeleted
+)
+
+// expireRoom archives or deletes the room when it is still expired. The room is marked as expiring
+// meanwhile, activating it waits until it is done.
+func (hub *Hub) expireRoom(roomId room.RoomID, now time.Time) (expiry, error) {
+	hub.Mu.Lock()
+	_, active := hub.ActiveRooms[roomId]
+	_, flushing := hub.flushes[roomId]
+	if active || flushing {
+		hub.Mu.Unlock()
+		return roomKept, nil
+	}
+
+	done := make(chan struct{})
+	hub.expiring[roomId] = done
+	hub.Mu.Unlock()
+
+	defer func() {
+		hub.Mu.Lock()
+		delete(hub.expiring, roomId)
+		hub.Mu.Unlock()
+		close(done)
+	}()
+
+	for attempt := 1; ; attempt++ {
+		// the listed activity may be outdated, the room could have been used since
+		r, err := hub.repo.FindRoom(roomId)
+		if err != nil || r == nil {
+			return roomKept, err
+		}
+
+		activity := r.Activity()
+		if !hub.retention.expired(activity, now) {
+			return roomKept, nil
+		}
+
+		// another instance serving the room would save it again without the rows deleted under it
+		served, err := hub.servedElsewhere(roomId)
+		if err != nil || served {
+			return roomKept, err
+		}
+
+		if activity.ArchivedAt != nil {
+			return roomDeleted, hub.repo.DeleteRoom(roomId)
+		}
+
+		r.Archive()
+		err = hub.repo.Save(r)
+
+		// saved by another instance since it was loaded, it is loaded and checked again
+		var conflict *room.ConflictError
+		if !errors.As(err, &conflict) || attempt == maxSaveAttempts {
+			return roomArchived, err
+		}
+	}
+}
+
+// servedElsewhere tells whether another instance is subscribed to the room's channel, so it serves the room
+// or is loading it. Rooms active on this instance aren't expired, so every subscriber is another instance.
+func (hub *Hub) servedElsewhere(roomId room.RoomID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	subscribers, err := hub.backplane.Subscribers(ctx, roomChannel(roomId))
+	return subscribers > 0, err
+}
+
+// expired tells whether the room is due to be archived or deleted
+func (p RetentionPolicy) expired(activity room.RoomActivity, now time.Time) bool {
+	if activity.ArchivedAt != nil {
+		return p.DeleteAfter > 0 && now.Sub(*activity.ArchivedAt) >= p.DeleteAfter
+	}
+
+	return p.ArchiveAfter > 0 && now.Sub(activity.LastActivityAt) >= p.ArchiveAfter
+}
@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/room"
+	"testing"
+	"time"
+)
+
+// stallingRepo holds every save until released
+type stallingRepo struct {
+	room.RoomRepo
+	stalled chan struct{}
+	release chan struct{}
+}
+
+func (r *stallingRepo) Save(room *room.Room) error {
+	r.stalled <- struct{}{}
+	<-r.release
+	return r.RoomRepo.Save(room)
+}
+
+func TestShouldArchiveThenDeleteInactiveRooms(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomArchiveAfter = 24 * time.Hour
+	cfg.RoomDeleteAfter = 24 * time.Hour
+	h, activeId := newTestHub(t, cfg)
+
+	idle, _, err := h.CreateRoom(nil, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t, h, activeId)
+	dial(t, srv, "Alice")
+	waitFor(t, func() bool { return connectedUsers(h, activeId) == 1 })
+
+	if err := h.sweepRooms(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := h.repo.FindRoom(idle.RoomID); r == nil || r.Activity().ArchivedAt != nil {
+		t.Fatal("Room archived before its time")
+	}
+
+	if err := h.sweepRooms(time.Now().Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := h.repo.FindRoom(idle.RoomID); r == nil || r.Activity().ArchivedAt == nil {
+		t.Fatal("Inactive room not archived")
+	}
+
+	if err := h.sweepRooms(time.Now().Add(96 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := h.repo.FindRoom(idle.RoomID); r != nil {
+		t.Error("Archived room not deleted")
+	}
+
+	r, _ := h.repo.FindRoom(activeId)
+	if r == nil || r.Activity().ArchivedAt != nil {
+		t.Error("Active room touched by the janitor")
+	}
+}
+
+func TestShouldRestoreArchivedRoomOnActivity(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomArchiveAfter = 24 * time.Hour
+	cfg.RoomDeleteAfter = 24 * time.Hour
+	h, roomId := newTestHub(t, cfg)
+
+	if err := h.sweepRooms(time.Now().Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t, h, roomId)
+	dial(t, srv, "Alice")
+	waitFor(t, func() bool { return connectedUsers(h, roomId) == 1 })
+
+	r, _ := h.repo.FindRoom(roomId)
+	if r == nil || r.Activity().ArchivedAt != nil {
+		t.Fatal("Room still archived after being used")
+	}
+}
+
+func TestShouldKeepHubRunningWhileArchiving(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomArchiveAfter = 24 * time.Hour
+	h, roomId := newTestHub(t, cfg)
+
+	repo := &stallingRepo{RoomRepo: h.repo, stalled: make(chan struct{}), release: make(chan struct{})}
+	h.repo = repo
+
+	swept := make(chan error)
+	go func() { swept <- h.sweepRooms(time.Now().Add(48 * time.Hour)) }()
+	<-repo.stalled
+
+	locked := make(chan struct{})
+	go func() {
+		h.Mu.Lock()
+		h.Mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Hub locked while the room is saved")
+	}
+
+	found := make(chan *FindRoomResponse)
+	go func() {
+		res, _ := h.FindRoom(roomId)
+		found <- res
+	}()
+	select {
+	case <-found:
+		t.Fatal("Room loaded while being archived")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(repo.release)
+	if err := <-swept; err != nil {
+		t.Fatal(err)
+	}
+	if res := <-found; res == nil || res.Room.Activity().ArchivedAt == nil {
+		t.Error("Room loaded before it was archived")
+	}
+}
+
+func TestShouldKeepRoomsServedByAnotherInstance(t *testing.T) {
+	bp := backplane.NewMemory()
+	first, second, roomId := newTestCluster(t, bp)
+	first.retention = RetentionPolicy{ArchiveAfter: 24 * time.Hour, DeleteAfter: 24 * time.Hour}
+
+	srv := newTestServer(t, second, roomId)
+	dial(t, srv, "Alice")
+	waitFor(t, func() bool { return connectedUsers(second, roomId) == 1 })
+
+	if err := first.sweepRooms(time.Now().Add(96 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := first.repo.FindRoom(roomId)
+	if r == nil || r.Activity().ArchivedAt != nil {
+		t.Error("Room served by another instance touched by the janitor")
+	}
+}
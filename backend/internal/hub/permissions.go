@@ -0,0 +1,47 @@
+package hub
+
+import "planning-poker/internal/user"
+
+var (
+	everyone        = []user.Role{user.RoleFacilitator, user.RoleVoter, user.RoleObserver}
+	participants    = []user.Role{user.RoleFacilitator, user.RoleVoter}
+	facilitatorOnly = []user.Role{user.RoleFacilitator}
+)
+
+// commandPermissions lists the roles allowed to run each command, commands missing here are unknown
+var commandPermissions = map[string][]user.Role{
+	"ADD_TOPIC":            participants,
+	"REMOVE_TOPIC":         facilitatorOnly,
+	"COMPLETE_TOPIC":       facilitatorOnly,
+	"RESET_TOPIC":          facilitatorOnly,
+	"VOTE_ON_TOPIC":        participants,
+	"CHANGE_CURRENT_TOPIC": facilitatorOnly,
+	"ADD_COMENT":           everyone,
+	"TOGGLE_VISIBILITY":    facilitatorOnly,
+	"CHANGE_TOPIC_DETAILS": facilitatorOnly,
+	"SET_USER_ROLE":        facilitatorOnly,
+	"CHANGE_DECK":          facilitatorOnly,
+	"START_TIMER":          facilitatorOnly,
+	"PAUSE_TIMER":          facilitatorOnly,
+	"RESUME_TIMER":         facilitatorOnly,
+	"EXTEND_TIMER":         facilitatorOnly,
+	"CANCEL_TIMER":         facilitatorOnly,
+	"REORDER_TOPICS":       facilitatorOnly,
+	"MOVE_TOPIC":           facilitatorOnly,
+	"NEXT_TOPIC":           facilitatorOnly,
+}
+
+func checkPermission(role user.Role, commandType string) error {
+	roles, ok := commandPermissions[commandType]
+	if !ok {
+		return ErrUnknownCommand
+	}
+
+	for _, allowed := range roles {
+		if allowed == role {
+			return nil
+		}
+	}
+
+	return ErrPermissionDenied
+}
@@ -0,0 +1,593 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/oklog/ulid/v2"
+	"log"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/room"
+	"planning-poker/internal/user"
+	"time"
+)
+
+// Every instance serving a room keeps its own copy of it. Instead of changing the room directly,
+// commands, joins and leaves are published on the room's backplane channel, and every instance
+// applies them in the order the channel delivers them, so the copies stay the same, sequence
+// numbers included. Whatever a message creates gets its id on the instance sending it.
+// An instance that finds it missed messages of the channel takes the room's state from another
+// instance again, the same way it does when activating the room.
+
+const (
+	msgJoin         = "JOIN"
+	msgLeave        = "LEAVE"
+	msgCommand      = "COMMAND"
+	msgImportTopics = "IMPORT_TOPICS"
+	msgTimerExpired = "TIMER_EXPIRED"
+	msgSyncRequest  = "SYNC_REQUEST"
+	msgSyncState    = "SYNC_STATE"
+	msgReload       = "RELOAD"
+)
+
+// messageTimeout bounds the wait for a published message to come back from the backplane
+const messageTimeout = 10 * time.Second
+
+var ErrBackplaneTimeout = errors.New("backplane didn't deliver the message in time")
+
+type roomMessage struct {
+	ID       string         `json:"id"`
+	Node     string         `json:"node"`
+	Kind     string         `json:"kind"`
+	User     user.User      `json:"user"`
+	Command  *IncMessage    `json:"command,omitempty"`
+	NewID    ulid.ULID      `json:"new_id"`
+	Drafts   []importDraft  `json:"drafts,omitempty"`
+	Timer    *room.Timer    `json:"timer,omitempty"`
+	SyncedTo string         `json:"synced_to,omitempty"`
+	State    *roomSyncState `json:"state,omitempty"`
+
+	// set by the backplane delivering the message
+	Seq  uint64 `json:"-"`
+	Lost bool   `json:"-"`
+}
+
+type importDraft struct {
+	TopicID     room.TopicID `json:"topic_id"`
+	Title       string       `json:"title"`
+	Url         string       `json:"url"`
+	Description string       `json:"description"`
+}
+
+// member is a user present in the room, on this instance or another one
+type member struct {
+	User user.User `json:"user"`
+	Node string    `json:"node"`
+}
+
+// roomSyncState is what an instance already serving a room hands to one activating it
+type roomSyncState struct {
+	Snapshot room.Snapshot `json:"snapshot"`
+	Members  []member      `json:"members"`
+}
+
+// pendingJoin is a connection waiting for its JOIN message to be applied
+type pendingJoin struct {
+	ws   *websocket.Conn
+	seen *StreamPosition
+}
+
+func roomChannel(roomId room.RoomID) string {
+	return "room:" + roomId.String()
+}
+
+func decodeMessage(delivered backplane.Message) (roomMessage, error) {
+	if delivered.Lost {
+		return roomMessage{Lost: true}, nil
+	}
+
+	var msg roomMessage
+	err := json.Unmarshal(delivered.Data, &msg)
+	msg.Seq = delivered.Seq
+
+	return msg, err
+}
+
+// missed tells whether messages of the room's channel were lost before msg, lastSeq being the number of
+// the message applied last, or 0 when nothing was applied yet
+func missed(msg roomMessage, lastSeq uint64) bool {
+	return msg.Lost || (lastSeq != 0 && msg.Seq != lastSeq+1)
+}
+
+func (hub *Hub) newMessage(kind string) roomMessage {
+	return roomMessage{
+		ID:   ulid.Make().String(),
+		Node: hub.node,
+		Kind: kind,
+	}
+}
+
+func (hub *Hub) publish(roomId room.RoomID, msg roomMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	return hub.backplane.Publish(ctx, roomChannel(roomId), data)
+}
+
+// publishAndWait publishes the message and waits until this instance applied it, returning the error applying it
+func (hub *Hub) publishAndWait(activeRoom *ActiveRoom, msg roomMessage, join *pendingJoin) error {
+	result := make(chan error, 1)
+
+	hub.Mu.Lock()
+	activeRoom.results[msg.ID] = result
+	if join != nil {
+		activeRoom.joins[msg.ID] = join
+	}
+	hub.Mu.Unlock()
+
+	defer func() {
+		hub.Mu.Lock()
+		delete(activeRoom.results, msg.ID)
+		delete(activeRoom.joins, msg.ID)
+		hub.Mu.Unlock()
+	}()
+
+	err := hub.publish(activeRoom.RoomID, msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(messageTimeout):
+		return ErrBackplaneTimeout
+	}
+}
+
+// completeMessage hands the result of applying a message to the goroutine that published it, must be called with hub.Mu held
+func completeMessage(activeRoom *ActiveRoom, msg roomMessage, err error) {
+	if result, ok := activeRoom.results[msg.ID]; ok {
+		result <- err
+	}
+}
+
+// acquireRoom returns the active room, activating it on this instance when needed.
+// The room stays active at least until releaseRoom is called.
+func (hub *Hub) acquireRoom(roomId room.RoomID) (*ActiveRoom, error) {
+	hub.Mu.Lock()
+	activeRoom, ok := hub.ActiveRooms[roomId]
+	if ok {
+		activeRoom.holds++
+		hub.Mu.Unlock()
+
+		<-activeRoom.ready
+		if activeRoom.err != nil {
+			return nil, activeRoom.err
+		}
+
+		return activeRoom, nil
+	}
+
+	activeRoom = &ActiveRoom{
+		RoomID:         roomId,
+		ConnectedUsers: make(map[user.UserID]*UserConnection),
+		PendingLeaves:  make(map[user.UserID]*PendingLeave),
+		Members:        make(map[user.UserID]member),
+		CloseChan:      make(chan struct{}),
+		ready:          make(chan struct{}),
+		results:        make(map[string]chan error),
+		joins:          make(map[string]*pendingJoin),
+		holds:          1,
+	}
+	hub.ActiveRooms[roomId] = activeRoom
+	hub.Mu.Unlock()
+
+	// the rest of the hub keeps running while the room is loaded
+	err := hub.activateRoom(activeRoom)
+	if err != nil {
+		hub.Mu.Lock()
+		activeRoom.err = err
+		delete(hub.ActiveRooms, roomId)
+		hub.Mu.Unlock()
+
+		close(activeRoom.ready)
+		return nil, err
+	}
+
+	close(activeRoom.ready)
+	log.Printf("Room %s enabled after first user", roomId)
+
+	return activeRoom, nil
+}
+
+// releaseRoom gives back a room taken with acquireRoom
+func (hub *Hub) releaseRoom(activeRoom *ActiveRoom) {
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	activeRoom.holds--
+	hub.deactivateIfIdle(activeRoom)
+}
+
+// deactivateIfIdle disables the room on this instance once nobody here uses it, must be called with hub.Mu held
+func (hub *Hub) deactivateIfIdle(activeRoom *ActiveRoom) {
+	if len(activeRoom.ConnectedUsers) > 0 || len(activeRoom.PendingLeaves) > 0 || activeRoom.holds > 0 {
+		return
+	}
+	if hub.ActiveRooms[activeRoom.RoomID] != activeRoom {
+		return
+	}
+
+	close(activeRoom.CloseChan)
+	delete(hub.ActiveRooms, activeRoom.RoomID)
+	go activeRoom.sub.Close()
+
+	// rooms that failed to activate have nothing to save
+	if activeRoom.Room != nil {
+		hub.flushDeactivated(activeRoom)
+	}
+
+	log.Printf("Room %s disabled due to inactivity\n", activeRoom.RoomID.String())
+}
+
+// activateRoom subscribes to the room's channel and loads the room, from another instance serving it
+// when there is one, otherwise from the repo
+func (hub *Hub) activateRoom(activeRoom *ActiveRoom) error {
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	sub, err := hub.backplane.Subscribe(ctx, roomChannel(activeRoom.RoomID))
+	if err != nil {
+		return err
+	}
+
+	state, backlog, lastSeq, err := hub.syncRoom(activeRoom.RoomID, sub)
+	if err != nil {
+		sub.Close()
+		return err
+	}
+
+	var r *room.Room
+	if state != nil {
+		r, err = room.FromSnapshot(state.Snapshot)
+	} else {
+		// the room may have been deactivated here moments ago, with its last changes still being saved
+		hub.waitFlushed(activeRoom.RoomID)
+		r, err = hub.repo.FindRoom(activeRoom.RoomID)
+		if r == nil && err == nil {
+			err = ErrRoomNotFound
+		}
+	}
+	if err != nil {
+		sub.Close()
+		return err
+	}
+
+	hub.Mu.Lock()
+	activeRoom.Room = r
+	activeRoom.sub = sub
+	if state != nil {
+		for _, m := range state.Members {
+			activeRoom.Members[m.User.UserID] = m
+		}
+	}
+	hub.Mu.Unlock()
+
+	go hub.HandleRoomBroadcast(activeRoom)
+	go hub.replicate(activeRoom, backlog, lastSeq)
+
+	return nil
+}
+
+// syncRoom asks the instances already serving the room for its state. Messages published after the request
+// aren't part of the state, they are returned to be applied on top of it, along with the number of the request
+// they follow. Without an answer within the sync timeout the room isn't active anywhere else and the state is nil.
+func (hub *Hub) syncRoom(roomId room.RoomID, sub backplane.Subscription) (*roomSyncState, []roomMessage, uint64, error) {
+	if hub.syncTimeout <= 0 {
+		return nil, nil, 0, nil
+	}
+
+	req := hub.newMessage(msgSyncRequest)
+	err := hub.publish(roomId, req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	timeout := time.After(hub.syncTimeout)
+	var requestSeq uint64
+	requested := false
+	var backlog []roomMessage
+
+	for {
+		select {
+		case delivered, ok := <-sub.Messages():
+			if !ok {
+				return nil, nil, 0, errors.New("backplane subscription closed")
+			}
+
+			msg, err := decodeMessage(delivered)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			switch {
+			case msg.ID == req.ID:
+				requested = true
+				requestSeq = msg.Seq
+			case !requested:
+				// already part of the state we'll get
+			case msg.Kind == msgSyncState && msg.SyncedTo == req.ID:
+				// kept so the numbers of the backlog follow each other
+				backlog = append(backlog, msg)
+				return msg.State, backlog, requestSeq, nil
+			default:
+				backlog = append(backlog, msg)
+			}
+		case <-timeout:
+			return nil, backlog, requestSeq, nil
+		}
+	}
+}
+
+// replicate is a goroutine for each active room applying the messages of the room's channel in order,
+// lastSeq is the number of the message the room's state goes up to
+func (hub *Hub) replicate(activeRoom *ActiveRoom, backlog []roomMessage, lastSeq uint64) {
+	for {
+		for len(backlog) > 0 {
+			msg := backlog[0]
+			backlog = backlog[1:]
+
+			// what is left of the backlog is part of the state taken again
+			if missed(msg, lastSeq) {
+				backlog, lastSeq = hub.resync(activeRoom)
+				continue
+			}
+
+			lastSeq = msg.Seq
+			hub.apply(activeRoom, msg)
+		}
+
+		select {
+		case delivered, ok := <-activeRoom.sub.Messages():
+			if !ok {
+				return
+			}
+
+			msg, err := decodeMessage(delivered)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			backlog = append(backlog, msg)
+		case <-activeRoom.CloseChan:
+			return
+		}
+	}
+}
+
+// resync takes the room's state from another instance serving it again, after messages of the room's channel
+// were lost, and sends it to the room's clients. It returns the messages to apply on top of the state and the
+// number of the message the state goes up to.
+func (hub *Hub) resync(activeRoom *ActiveRoom) ([]roomMessage, uint64) {
+	log.Printf("Room %s missed messages, syncing it again", activeRoom.RoomID)
+
+	state, backlog, lastSeq, err := hub.syncRoom(activeRoom.RoomID, activeRoom.sub)
+	if err != nil {
+		log.Println(err)
+		return nil, 0
+	}
+
+	// nobody else serves the room, so the messages missed were only this instance's own
+	if state == nil {
+		return backlog, lastSeq
+	}
+
+	err = activeRoom.Room.Restore(state.Snapshot)
+	if err != nil {
+		log.Println(err)
+		return backlog, lastSeq
+	}
+
+	hub.Mu.Lock()
+	defer hub.Mu.Unlock()
+
+	activeRoom.Members = make(map[user.UserID]member)
+	for _, m := range state.Members {
+		activeRoom.Members[m.User.UserID] = m
+	}
+	for _, userConn := range activeRoom.ConnectedUsers {
+		if _, ok := activeRoom.Members[userConn.User.UserID]; !ok {
+			u := userConn.User
+			u.Role = activeRoom.Room.UserRole(u.UserID)
+			activeRoom.Members[u.UserID] = member{User: u, Node: hub.node}
+		}
+	}
+
+	hub.resendSnapshots(activeRoom)
+
+	return backlog, lastSeq
+}
+
+// resendSnapshots sends a snapshot to every client of the room on this instance, after the room's state
+// was replaced. Must be called with hub.Mu held.
+func (hub *Hub) resendSnapshots(activeRoom *ActiveRoom) {
+	for _, userConn := range activeRoom.ConnectedUsers {
+		err := hub.syncClient(activeRoom, userConn, nil)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (hub *Hub) apply(activeRoom *ActiveRoom, msg roomMessage) {
+	r := activeRoom.Room
+
+	switch msg.Kind {
+	// broadcasting waits on the room's broadcaster when its queue is full, which needs hub.Mu,
+	// so events are only broadcast once it is released
+	case msgJoin:
+		hub.Mu.Lock()
+		joined := hub.applyJoin(activeRoom, msg)
+		hub.Mu.Unlock()
+
+		if joined != nil {
+			r.BroadcastEvent(*joined)
+		}
+	case msgLeave:
+		hub.Mu.Lock()
+		left := hub.applyLeave(activeRoom, msg)
+		hub.Mu.Unlock()
+
+		if left != nil {
+			r.BroadcastEvent(*left)
+		}
+	case msgCommand:
+		err := hub.applyCommand(r, msg)
+		// every instance serving the room saves it, those behind the others find it saved already
+		if err == nil {
+			hub.markDirty(activeRoom)
+		}
+		hub.Mu.Lock()
+		completeMessage(activeRoom, msg, err)
+		hub.Mu.Unlock()
+	case msgImportTopics:
+		err := applyImport(r, msg)
+		if err == nil {
+			hub.markDirty(activeRoom)
+		}
+		hub.Mu.Lock()
+		completeMessage(activeRoom, msg, err)
+		hub.Mu.Unlock()
+	case msgTimerExpired:
+		// every instance notices the expiry, the one whose message comes first saves it
+		if r.ExpireTimer(*msg.Timer) && msg.Node == hub.node {
+			hub.markDirty(activeRoom)
+		}
+	case msgSyncRequest:
+		if msg.Node != hub.node {
+			hub.answerSync(activeRoom, msg)
+		}
+	case msgReload:
+		// the stored room has no event stream, the room's own goes on
+		snapshot := msg.State.Snapshot
+		snapshot.Seq = r.LastSeq()
+
+		err := r.Restore(snapshot)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		hub.Mu.Lock()
+		hub.resendSnapshots(activeRoom)
+		hub.Mu.Unlock()
+	}
+}
+
+// applyJoin adds the user to the room, and connects it when it joined through this instance. It returns the event
+// to broadcast, if any. Must be called with hub.Mu held.
+func (hub *Hub) applyJoin(activeRoom *ActiveRoom, msg roomMessage) *room.UserJoinedRoom {
+	u := msg.User
+
+	// the user is resuming a session that is still inside the grace period, or reloaded the page
+	// before the old connection was dropped, so the rest of the room never saw them leave
+	_, resumed := activeRoom.Members[u.UserID]
+	if pending, ok := activeRoom.PendingLeaves[u.UserID]; ok {
+		pending.Timer.Stop()
+		delete(activeRoom.PendingLeaves, u.UserID)
+	}
+	if oldConn, ok := activeRoom.ConnectedUsers[u.UserID]; ok {
+		oldConn.Close(websocket.CloseNormalClosure, "replaced by a new connection")
+		delete(activeRoom.ConnectedUsers, u.UserID)
+	}
+
+	u.Role = activeRoom.Room.Join(u)
+
+	if join, ok := activeRoom.joins[msg.ID]; ok {
+		completeMessage(activeRoom, msg, hub.connectUser(activeRoom, join, u, resumed))
+	}
+
+	// added after the snapshot was sent, as it comes before the UserJoinedRoom event
+	activeRoom.Members[u.UserID] = member{User: u, Node: msg.Node}
+
+	hub.deactivateIfIdle(activeRoom)
+
+	// nobody listens to a room deactivated meanwhile
+	if resumed || hub.ActiveRooms[activeRoom.RoomID] != activeRoom {
+		return nil
+	}
+
+	return &room.UserJoinedRoom{
+		UserID:   u.UserID,
+		Username: u.Name,
+		Role:     u.Role,
+	}
+}
+
+// applyLeave removes the user from the room, unless it joined again through another instance since. It returns
+// the event to broadcast, if any. Must be called with hub.Mu held.
+func (hub *Hub) applyLeave(activeRoom *ActiveRoom, msg roomMessage) *room.UserLeftRoom {
+	m, ok := activeRoom.Members[msg.User.UserID]
+	if !ok || m.Node != msg.Node {
+		return nil
+	}
+
+	delete(activeRoom.Members, msg.User.UserID)
+	return &room.UserLeftRoom{UserID: msg.User.UserID}
+}
+
+func applyImport(r *room.Room, msg roomMessage) error {
+	err := checkPermission(r.UserRole(msg.User.UserID), "ADD_TOPIC")
+	if err != nil {
+		return err
+	}
+
+	drafts := make([]room.TopicDraft, 0, len(msg.Drafts))
+	for _, d := range msg.Drafts {
+		drafts = append(drafts, room.TopicDraft{
+			TopicID:     d.TopicID,
+			Title:       d.Title,
+			Url:         d.Url,
+			Description: d.Description,
+		})
+	}
+
+	return r.ImportTopics(drafts)
+}
+
+// answerSync sends the room's state to an instance activating it, as of the position of its request
+func (hub *Hub) answerSync(activeRoom *ActiveRoom, req roomMessage) {
+	hub.Mu.Lock()
+	snapshot, err := activeRoom.Room.Snapshot()
+	members := make([]member, 0, len(activeRoom.Members))
+	for _, m := range activeRoom.Members {
+		members = append(members, m)
+	}
+	hub.Mu.Unlock()
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	msg := hub.newMessage(msgSyncState)
+	msg.SyncedTo = req.ID
+	msg.State = &roomSyncState{
+		Snapshot: snapshot,
+		Members:  members,
+	}
+
+	err = hub.publish(activeRoom.RoomID, msg)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,251 @@
+package hub
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/room"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestCluster starts two hubs sharing a repo and a backplane, serving the same room
+func newTestCluster(t *testing.T, bp backplane.Backplane) (*Hub, *Hub, room.RoomID) {
+	cfg := testConfig()
+	cfg.SyncTimeout = 200 * time.Millisecond
+
+	repo := room.NewRoomRepoMemory()
+	first := NewHub(cfg, &repo, bp)
+	second := NewHub(cfg, &repo, bp)
+
+	r, _, err := first.CreateRoom(nil, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &first, &second, r.RoomID
+}
+
+func clusterBackplanes(t *testing.T, test func(t *testing.T, bp backplane.Backplane)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, backplane.NewMemory())
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		srv := miniredis.RunT(t)
+
+		bp, err := backplane.NewRedis("redis://" + srv.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bp.Close() })
+
+		test(t, bp)
+	})
+}
+
+// readUntil reads frames until one of the given type, event frames are matched on their event name
+func readUntil(t *testing.T, ws *websocket.Conn, frameType string) map[string]interface{} {
+	for {
+		frame := readFrame(t, ws)
+		if frame["type"] == frameType || frame["Type"] == frameType {
+			return frame
+		}
+	}
+}
+
+func TestShouldShareRoomAcrossInstances(t *testing.T) {
+	clusterBackplanes(t, func(t *testing.T, bp backplane.Backplane) {
+		first, second, roomId := newTestCluster(t, bp)
+		srvFirst := newTestServer(t, first, roomId)
+		srvSecond := newTestServer(t, second, roomId)
+
+		alice := dial(t, srvFirst, "alice")
+		readUntil(t, alice, "UserJoinedRoom")
+
+		bob := dial(t, srvSecond, "bob")
+		snapshot := readUntil(t, bob, "ROOM_SNAPSHOT")
+		if snapshot["seq"] != float64(1) {
+			t.Errorf("Expected snapshot at seq 1, got %v", snapshot["seq"])
+		}
+		users := snapshot["connected_users"].([]interface{})
+		if len(users) != 1 || users[0].(map[string]interface{})["name"] != "alice" {
+			t.Errorf("Expected alice connected through the other instance, got %v", users)
+		}
+
+		if frame := readUntil(t, alice, "UserJoinedRoom"); frame["Seq"] != float64(2) {
+			t.Errorf("Expected bob joining at seq 2, got %v", frame)
+		}
+		readUntil(t, bob, "UserJoinedRoom")
+
+		err := alice.WriteJSON(map[string]interface{}{
+			"type":       "ADD_TOPIC",
+			"request_id": "1",
+			"data":       map[string]string{"title": "Shared topic"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frame := readUntil(t, bob, "TopicAddedEvent")
+		if frame["Seq"] != float64(3) || frame["Payload"].(map[string]interface{})["title"] != "Shared topic" {
+			t.Errorf("Unexpected topic event on the other instance: %v", frame)
+		}
+
+		// both copies of the room agree on the topic id
+		first.Mu.Lock()
+		firstOrder := first.ActiveRooms[roomId].Room.OrderedTopics()
+		first.Mu.Unlock()
+		second.Mu.Lock()
+		secondOrder := second.ActiveRooms[roomId].Room.OrderedTopics()
+		second.Mu.Unlock()
+		if len(firstOrder) != 1 || len(secondOrder) != 1 || firstOrder[0].TopicID != secondOrder[0].TopicID {
+			t.Error("Instances diverged")
+		}
+
+		bob.Close()
+		if frame := readUntil(t, alice, "UserLeftRoom"); frame["Seq"] != float64(4) {
+			t.Errorf("Expected bob leaving at seq 4, got %v", frame)
+		}
+		waitFor(t, func() bool { return connectedUsers(second, roomId) == 0 && len(second.ActiveRooms) == 0 })
+	})
+}
+
+func TestShouldRejectCommandsOnEveryInstance(t *testing.T) {
+	clusterBackplanes(t, func(t *testing.T, bp backplane.Backplane) {
+		first, second, roomId := newTestCluster(t, bp)
+		srvFirst := newTestServer(t, first, roomId)
+		srvSecond := newTestServer(t, second, roomId)
+
+		alice := dial(t, srvFirst, "alice")
+		readUntil(t, alice, "UserJoinedRoom")
+
+		bob := dial(t, srvSecond, "bob")
+		readUntil(t, bob, "UserJoinedRoom")
+
+		// bob is a voter, only facilitators can change the deck
+		bob.WriteJSON(map[string]interface{}{
+			"type":       "CHANGE_DECK",
+			"request_id": "1",
+			"data":       map[string]string{"deck": "tshirt"},
+		})
+
+		frame := readUntil(t, bob, "ERROR")
+		if frame["code"] != ErrCodePermissionDenied || frame["request_id"] != "1" {
+			t.Errorf("Unexpected error: %v", frame)
+		}
+	})
+}
+
+func TestShouldSaveFromEveryInstance(t *testing.T) {
+	clusterBackplanes(t, func(t *testing.T, bp backplane.Backplane) {
+		first, second, roomId := newTestCluster(t, bp)
+		srvFirst := newTestServer(t, first, roomId)
+		srvSecond := newTestServer(t, second, roomId)
+
+		alice := dial(t, srvFirst, "alice")
+		readUntil(t, alice, "UserJoinedRoom")
+		bob := dial(t, srvSecond, "bob")
+		readUntil(t, bob, "UserJoinedRoom")
+
+		// each instance saves the commands sent through it, over the versions saved by the other one
+		for i, ws := range []*websocket.Conn{alice, bob, alice} {
+			ws.WriteJSON(map[string]interface{}{
+				"type":       "ADD_TOPIC",
+				"request_id": "1",
+				"data":       map[string]string{"title": "Topic " + string(rune('A'+i))},
+			})
+			if frame := readUntil(t, ws, "ACK"); frame["request_id"] != "1" {
+				t.Fatalf("Unexpected ack: %v", frame)
+			}
+		}
+
+		waitFor(t, func() bool {
+			saved, err := first.repo.FindRoom(roomId)
+			return err == nil && saved != nil && len(saved.Topics) == 3
+		})
+	})
+}
+
+// lossyBackplane drops the messages a subscriber of this instance shouldn't get, like a backplane losing them
+type lossyBackplane struct {
+	backplane.Backplane
+	drop func(msg roomMessage) bool
+}
+
+func (b *lossyBackplane) Subscribe(ctx context.Context, channel string) (backplane.Subscription, error) {
+	sub, err := b.Backplane.Subscribe(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	lossy := &lossySubscription{Subscription: sub, out: make(chan backplane.Message)}
+	go func() {
+		defer close(lossy.out)
+		for delivered := range sub.Messages() {
+			if msg, err := decodeMessage(delivered); err == nil && b.drop(msg) {
+				continue
+			}
+			lossy.out <- delivered
+		}
+	}()
+
+	return lossy, nil
+}
+
+type lossySubscription struct {
+	backplane.Subscription
+	out chan backplane.Message
+}
+
+func (s *lossySubscription) Messages() <-chan backplane.Message {
+	return s.out
+}
+
+func TestShouldSyncAgainAfterMissingMessages(t *testing.T) {
+	cfg := testConfig()
+	cfg.SyncTimeout = 200 * time.Millisecond
+
+	bp := backplane.NewMemory()
+	repo := room.NewRoomRepoMemory()
+	first := NewHub(cfg, &repo, bp)
+	var dropped atomic.Bool
+	second := NewHub(cfg, &repo, &lossyBackplane{Backplane: bp, drop: func(msg roomMessage) bool {
+		return msg.Kind == msgCommand && msg.Command.Type == "ADD_TOPIC" && dropped.CompareAndSwap(false, true)
+	}})
+
+	r, _, err := first.CreateRoom(nil, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvFirst := newTestServer(t, &first, r.RoomID)
+	srvSecond := newTestServer(t, &second, r.RoomID)
+
+	alice := dial(t, srvFirst, "alice")
+	readUntil(t, alice, "UserJoinedRoom")
+	bob := dial(t, srvSecond, "bob")
+	readUntil(t, bob, "ROOM_SNAPSHOT")
+
+	// the second instance never sees the first topic, and notices with the next message
+	for i := 0; i < 2; i++ {
+		alice.WriteJSON(map[string]interface{}{
+			"type":       "ADD_TOPIC",
+			"request_id": "1",
+			"data":       map[string]string{"title": "Topic"},
+		})
+		readUntil(t, alice, "ACK")
+	}
+
+	frame := readUntil(t, bob, "ROOM_SNAPSHOT")
+	if topics := frame["room"].(map[string]interface{})["topics"].(map[string]interface{}); len(topics) != 2 {
+		t.Errorf("Expected both topics after syncing again, got %d", len(topics))
+	}
+
+	waitFor(t, func() bool {
+		second.Mu.Lock()
+		defer second.Mu.Unlock()
+		return len(second.ActiveRooms[r.RoomID].Room.OrderedTopics()) == 2
+	})
+}
@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"time"
+)
+
+// clients are told to wait a random delay within this range before reconnecting,
+// so they don't all hit the instances left at once
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Second
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+type ServerRestartingResponse struct {
+	Type             string `json:"type"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms"`
+}
+
+// Shutdown refuses new connections, tells every client to reconnect later and closes its connection,
+// then saves every active room. It returns once every close frame is written and every room saved,
+// or when ctx is done.
+func (hub *Hub) Shutdown(ctx context.Context) error {
+	hub.Mu.Lock()
+	hub.closing = true
+
+	var conns []*UserConnection
+	var rooms []*ActiveRoom
+	var leaves []roomLeave
+	for _, activeRoom := range hub.ActiveRooms {
+		// rooms still activating refuse the connections waiting on them
+		if activeRoom.Room == nil {
+			continue
+		}
+		rooms = append(rooms, activeRoom)
+
+		for _, userConn := range activeRoom.ConnectedUsers {
+			conns = append(conns, userConn)
+			leaves = append(leaves, hub.leaveRoom(activeRoom, userConn.User))
+		}
+		for _, pending := range activeRoom.PendingLeaves {
+			pending.Timer.Stop()
+			leaves = append(leaves, hub.leaveRoom(activeRoom, pending.User))
+		}
+	}
+	hub.Mu.Unlock()
+
+	for _, userConn := range conns {
+		delay := minReconnectDelay + time.Duration(rand.Int63n(int64(maxReconnectDelay-minReconnectDelay)))
+		userConn.CloseWith(ServerRestartingResponse{
+			Type:             "SERVER_RESTARTING",
+			ReconnectAfterMs: delay.Milliseconds(),
+		}, websocket.CloseServiceRestart, "server restarting")
+	}
+
+	for _, leave := range leaves {
+		hub.publishLeave(leave)
+	}
+
+	var errs []error
+	for _, activeRoom := range rooms {
+		err := hub.flushRoom(activeRoom)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// rooms deactivated above are flushed in the background
+	err := hub.waitFlushes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, userConn := range conns {
+		select {
+		case <-userConn.closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return errors.Join(errs...)
+}
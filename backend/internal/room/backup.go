@@ -0,0 +1,321 @@
+package room
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A backup is a gzip compressed stream of json lines: a header naming the format and its version, a line
+// for every room, and a trailer counting the rooms, so a backup cut short is told apart from a complete one.
+// Every room is kept as a snapshot along with its checksum.
+
+const (
+	backupFormat = "planning-poker-rooms"
+	// BackupVersion is the version of the backup format written, backups of newer versions can't be read
+	BackupVersion = 1
+)
+
+var (
+	ErrNotBackup         = errors.New("not a room backup")
+	ErrBackupTruncated   = errors.New("backup is truncated")
+	ErrBackupUnsupported = errors.New("backup was made by a newer version")
+)
+
+type BackupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupRoom struct {
+	RoomID   RoomID   `json:"room_id"`
+	Checksum string   `json:"checksum"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type backupTrailer struct {
+	Rooms int `json:"rooms"`
+}
+
+// backupLine is a single line of a backup, exactly one of its fields is set
+type backupLine struct {
+	Header  *BackupHeader  `json:"header,omitempty"`
+	Room    *backupRoom    `json:"room,omitempty"`
+	Trailer *backupTrailer `json:"trailer,omitempty"`
+}
+
+// BackupRoomError is a room of the backup that is damaged, the rooms after it can still be read
+type BackupRoomError struct {
+	RoomID RoomID
+	Err    error
+}
+
+func (e *BackupRoomError) Error() string {
+	return fmt.Sprintf("room %s: %v", e.RoomID, e.Err)
+}
+
+func (e *BackupRoomError) Unwrap() error {
+	return e.Err
+}
+
+type BackupWriter struct {
+	gz    *gzip.Writer
+	enc   *json.Encoder
+	rooms int
+}
+
+// NewBackupWriter starts a backup, Close must be called to complete it
+func NewBackupWriter(w io.Writer, createdAt time.Time) (*BackupWriter, error) {
+	gz := gzip.NewWriter(w)
+	b := &BackupWriter{gz: gz, enc: json.NewEncoder(gz)}
+
+	err := b.enc.Encode(backupLine{Header: &BackupHeader{
+		Format:    backupFormat,
+		Version:   BackupVersion,
+		CreatedAt: createdAt.UTC(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *BackupWriter) Write(r *Room) error {
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	checksum, err := snapshotChecksum(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = b.enc.Encode(backupLine{Room: &backupRoom{
+		RoomID:   r.RoomID,
+		Checksum: checksum,
+		Snapshot: snapshot,
+	}})
+	if err != nil {
+		return err
+	}
+
+	b.rooms++
+	return nil
+}
+
+// Close writes the trailer and flushes the backup, it doesn't close the underlying writer
+func (b *BackupWriter) Close() error {
+	err := b.enc.Encode(backupLine{Trailer: &backupTrailer{Rooms: b.rooms}})
+	if err != nil {
+		return err
+	}
+
+	return b.gz.Close()
+}
+
+type BackupReader struct {
+	Header BackupHeader
+
+	dec   *json.Decoder
+	rooms int
+	seen  map[RoomID]bool
+}
+
+// NewBackupReader reads the header of a backup, refusing anything else and backups of newer versions
+func NewBackupReader(rd io.Reader) (*BackupReader, error) {
+	gz, err := gzip.NewReader(rd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotBackup, err)
+	}
+
+	b := &BackupReader{dec: json.NewDecoder(gz), seen: make(map[RoomID]bool)}
+
+	var line backupLine
+	err = b.dec.Decode(&line)
+	if err != nil || line.Header == nil || line.Header.Format != backupFormat {
+		return nil, ErrNotBackup
+	}
+	if line.Header.Version > BackupVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrBackupUnsupported, line.Header.Version)
+	}
+
+	b.Header = *line.Header
+	return b, nil
+}
+
+// Next returns the next room of the backup, and nil once every room was read and the backup found complete.
+// A damaged room is returned as a *BackupRoomError, reading can go on after it. Any other error ends the backup.
+func (b *BackupReader) Next() (*Room, error) {
+	var line backupLine
+	err := b.dec.Decode(&line)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrBackupTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if line.Trailer != nil {
+		return nil, b.finish(*line.Trailer)
+	}
+	if line.Room == nil {
+		return nil, errors.New("unexpected line in backup")
+	}
+
+	b.rooms++
+	entry := line.Room
+	if b.seen[entry.RoomID] {
+		return nil, &BackupRoomError{RoomID: entry.RoomID, Err: errors.New("room backed up twice")}
+	}
+	b.seen[entry.RoomID] = true
+
+	r, err := entry.restore()
+	if err != nil {
+		return nil, &BackupRoomError{RoomID: entry.RoomID, Err: err}
+	}
+
+	return r, nil
+}
+
+// finish checks the trailer, and that nothing follows it. The gzip checksum is verified once the end is reached.
+func (b *BackupReader) finish(trailer backupTrailer) error {
+	if trailer.Rooms != b.rooms {
+		return fmt.Errorf("backup should hold %d rooms, found %d", trailer.Rooms, b.rooms)
+	}
+
+	var extra json.RawMessage
+	err := b.dec.Decode(&extra)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return errors.New("unexpected data after the end of the backup")
+}
+
+// restore rebuilds the room after checking it wasn't altered and is consistent
+func (entry *backupRoom) restore() (*Room, error) {
+	checksum, err := snapshotChecksum(entry.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != entry.Checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	r, err := FromSnapshot(entry.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if r.RoomID != entry.RoomID {
+		return nil, fmt.Errorf("snapshot is of room %s", r.RoomID)
+	}
+
+	err = r.checkIntegrity()
+	if err != nil {
+		return nil, err
+	}
+
+	// the stores only write what changed since the room was loaded, a restored room is new to them
+	r.markAllChanged()
+
+	return r, nil
+}
+
+// checkIntegrity makes sure the references between the parts of the room hold
+func (r *Room) checkIntegrity() error {
+	for topicId, topic := range r.Topics {
+		if topic == nil || topic.TopicID != topicId {
+			return fmt.Errorf("topic %s doesn't match its key", topicId)
+		}
+	}
+	for userId, participant := range r.Participants {
+		if participant == nil || participant.UserID != userId {
+			return fmt.Errorf("participant %s doesn't match its key", userId)
+		}
+	}
+	if r.CurrentTopicID != nil {
+		if _, ok := r.Topics[*r.CurrentTopicID]; !ok {
+			return fmt.Errorf("current topic %s is missing", *r.CurrentTopicID)
+		}
+	}
+
+	return nil
+}
+
+func snapshotChecksum(snapshot Snapshot) (string, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// BackupRooms writes every room of the repo into the backup, returning how many were written
+func BackupRooms(from RoomRepo, to *BackupWriter) (int, error) {
+	activities, err := from.ListRoomActivity()
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, activity := range activities {
+		r, err := from.FindRoom(activity.RoomID)
+		if err != nil {
+			return written, fmt.Errorf("loading room %s: %w", activity.RoomID, err)
+		}
+		// deleted since it was listed
+		if r == nil {
+			continue
+		}
+
+		err = to.Write(r)
+		if err != nil {
+			return written, fmt.Errorf("writing room %s: %w", activity.RoomID, err)
+		}
+		written++
+	}
+
+	return written, nil
+}
+
+// RestoreRooms saves every room of the backup into the repo. Rooms the repo already has are skipped,
+// unless replace is set, then they are deleted first.
+func RestoreRooms(from *BackupReader, to RoomRepo, replace bool) (restored int, skipped int, err error) {
+	for {
+		r, err := from.Next()
+		if err != nil {
+			return restored, skipped, err
+		}
+		if r == nil {
+			return restored, skipped, nil
+		}
+
+		existing, err := to.FindRoom(r.RoomID)
+		if err != nil {
+			return restored, skipped, fmt.Errorf("loading room %s: %w", r.RoomID, err)
+		}
+		if existing != nil && !replace {
+			skipped++
+			continue
+		}
+
+		err = replaceRoom(to, r, existing)
+		if err != nil {
+			return restored, skipped, err
+		}
+		restored++
+	}
+}
@@ -0,0 +1,155 @@
+package room
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"io"
+	"planning-poker/internal/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func backupOf(t *testing.T, rooms ...*Room) []byte {
+	repo := NewRoomRepoMemory()
+	for _, r := range rooms {
+		if err := repo.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := NewBackupWriter(&buf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written, err := BackupRooms(&repo, w); err != nil || written != len(rooms) {
+		t.Fatalf("Expected %d rooms backed up, got %d: %v", len(rooms), written, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// rewriteBackup changes the uncompressed content of a backup
+func rewriteBackup(t *testing.T, data []byte, rewrite func(string) string) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(rewrite(string(content))))
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestRepoShouldRestoreBackup(t *testing.T) {
+	room, topicId := newRoomVotingTopic(t)
+	voter := user.NewUser(ulid.Make(), "Alice")
+	room.Join(voter)
+	room.VoteOnTopic(voter.UserID, topicId, "5")
+	other := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	data := backupOf(t, room, &other)
+
+	runRepoContract(t, func(t *testing.T, repo RoomRepo) {
+		r, err := NewBackupReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored, skipped, err := RestoreRooms(r, repo, false); err != nil || restored != 2 || skipped != 0 {
+			t.Fatalf("Expected 2 rooms restored, got %d %d: %v", restored, skipped, err)
+		}
+
+		loaded, err := repo.FindRoom(room.RoomID)
+		if err != nil || loaded == nil {
+			t.Fatal("Room not restored", err)
+		}
+		if topic := loaded.Topics[topicId]; topic == nil || topic.ClientVotes[voter.UserID] != "5" || len(loaded.Participants) != 1 {
+			t.Error("Room not restored in full")
+		}
+
+		// restoring again leaves the rooms alone, unless they are replaced
+		r, _ = NewBackupReader(bytes.NewReader(data))
+		if restored, skipped, err := RestoreRooms(r, repo, false); err != nil || restored != 0 || skipped != 2 {
+			t.Errorf("Expected every room skipped, got %d %d: %v", restored, skipped, err)
+		}
+		r, _ = NewBackupReader(bytes.NewReader(data))
+		if restored, _, err := RestoreRooms(r, repo, true); err != nil || restored != 2 {
+			t.Errorf("Expected every room replaced, got %d: %v", restored, err)
+		}
+	})
+}
+
+func TestShouldFindDamagedRoomsInBackup(t *testing.T) {
+	damaged := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	damaged.AddTopic(ulid.Make(), "Original title", "", "")
+	intact := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	data := rewriteBackup(t, backupOf(t, &damaged, &intact), func(content string) string {
+		return strings.Replace(content, "Original title", "Altered title", 1)
+	})
+
+	r, err := NewBackupReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []RoomID
+	var broken []RoomID
+	for {
+		room, err := r.Next()
+		var roomErr *BackupRoomError
+		if errors.As(err, &roomErr) {
+			broken = append(broken, roomErr.RoomID)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if room == nil {
+			break
+		}
+		found = append(found, room.RoomID)
+	}
+
+	if len(broken) != 1 || broken[0] != damaged.RoomID {
+		t.Errorf("Expected the altered room reported, got %v", broken)
+	}
+	if len(found) != 1 || found[0] != intact.RoomID {
+		t.Errorf("Expected the intact room read, got %v", found)
+	}
+}
+
+func TestShouldRefuseTruncatedBackup(t *testing.T) {
+	first := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	second := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	data := rewriteBackup(t, backupOf(t, &first, &second), func(content string) string {
+		lines := strings.SplitAfter(content, "\n")
+		return strings.Join(lines[:len(lines)-3], "")
+	})
+
+	r, err := NewBackupReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrBackupTruncated) {
+		t.Errorf("Expected a truncated backup, got %v", err)
+	}
+
+	if _, err := NewBackupReader(strings.NewReader("not a backup")); !errors.Is(err, ErrNotBackup) {
+		t.Errorf("Expected other files refused, got %v", err)
+	}
+}
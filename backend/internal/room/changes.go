@@ -0,0 +1,302 @@
+package room
+
+import (
+	"planning-poker/internal/user"
+	"time"
+)
+
+type voteKey struct {
+	TopicID TopicID
+	UserID  user.UserID
+}
+
+type roundKey struct {
+	TopicID TopicID
+	Number  int
+}
+
+// changeSet records which rows of a room changed since it was last saved, so repos can write only those.
+// Rooms that were never saved have every row changed.
+type changeSet struct {
+	all           bool
+	room          bool
+	activity      bool
+	topics        map[TopicID]struct{}
+	removedTopics map[TopicID]struct{}
+	votes         map[voteKey]struct{}
+	comments      map[CommentID]TopicID
+	rounds        map[roundKey]struct{}
+	participants  map[user.UserID]struct{}
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{
+		topics:        make(map[TopicID]struct{}),
+		removedTopics: make(map[TopicID]struct{}),
+		votes:         make(map[voteKey]struct{}),
+		comments:      make(map[CommentID]TopicID),
+		rounds:        make(map[roundKey]struct{}),
+		participants:  make(map[user.UserID]struct{}),
+	}
+}
+
+// the touch methods must be called with the room lock held
+
+func (r *Room) trackedChanges() *changeSet {
+	if r.changes == nil {
+		r.changes = newChangeSet()
+	}
+
+	return r.changes
+}
+
+// touchRoom marks the room row, holding the current topic, deck, timer and topic order
+func (r *Room) touchRoom() {
+	r.trackedChanges().room = true
+}
+
+// touchActivity marks the last activity and archival time of the room, which change with every other row
+func (r *Room) touchActivity() {
+	r.trackedChanges().activity = true
+}
+
+// touchTopic marks the columns of the topic itself, not its votes, comments or rounds
+func (r *Room) touchTopic(topicId TopicID) {
+	r.trackedChanges().topics[topicId] = struct{}{}
+}
+
+func (r *Room) touchRemovedTopic(topicId TopicID) {
+	changes := r.trackedChanges()
+	changes.removedTopics[topicId] = struct{}{}
+	delete(changes.topics, topicId)
+}
+
+func (r *Room) touchVote(topicId TopicID, userId user.UserID) {
+	r.trackedChanges().votes[voteKey{TopicID: topicId, UserID: userId}] = struct{}{}
+}
+
+// touchVotes marks every vote of the topic, before they are cleared
+func (r *Room) touchVotes(topic *Topic) {
+	for userId := range topic.ClientVotes {
+		r.touchVote(topic.TopicID, userId)
+	}
+}
+
+func (r *Room) touchComment(topicId TopicID, commentId CommentID) {
+	r.trackedChanges().comments[commentId] = topicId
+}
+
+func (r *Room) touchRound(topicId TopicID, number int) {
+	r.trackedChanges().rounds[roundKey{TopicID: topicId, Number: number}] = struct{}{}
+}
+
+func (r *Room) touchParticipant(userId user.UserID) {
+	r.trackedChanges().participants[userId] = struct{}{}
+}
+
+// roomRow is the room itself without its topics and participants
+type roomRow struct {
+	RoomID         RoomID
+	CreatedAt      time.Time
+	CurrentTopicID *TopicID
+	Deck           Deck
+	Timer          *Timer
+	TopicOrder     []TopicID
+	LastActivityAt time.Time
+	ArchivedAt     *time.Time
+}
+
+type voteRow struct {
+	TopicID TopicID
+	UserID  user.UserID
+	Points  string
+	// Deleted votes were cleared or dropped since the last save
+	Deleted bool
+}
+
+type commentRow struct {
+	TopicID TopicID
+	Comment Comment
+}
+
+type roundRow struct {
+	TopicID TopicID
+	Round   Round
+}
+
+// roomDelta holds copies of the rows of a room that changed, so they can be written without holding the room lock.
+// A full delta has every row of the room, and the rows missing from it are gone.
+type roomDelta struct {
+	Full bool
+	Room *roomRow
+	// Activity is set when anything changed, the room row carries it as well
+	Activity      *RoomActivity
+	Stamp         saveStamp
+	Topics        []Topic
+	RemovedTopics []TopicID
+	Votes         []voteRow
+	Comments      []commentRow
+	Rounds        []roundRow
+	Participants  []Participant
+}
+
+// takeDelta returns the rows changed since the last call and starts tracking changes anew
+func (r *Room) takeDelta() roomDelta {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changes := r.trackedChanges()
+	r.changes = newChangeSet()
+
+	if changes.all {
+		return r.fullDelta()
+	}
+
+	delta := roomDelta{Stamp: r.nextStamp()}
+	if changes.room {
+		row := r.roomRow()
+		delta.Room = &row
+	}
+	if changes.activity {
+		activity := r.activity()
+		delta.Activity = &activity
+	}
+
+	for topicId := range changes.removedTopics {
+		delta.RemovedTopics = append(delta.RemovedTopics, topicId)
+	}
+
+	for topicId := range changes.topics {
+		if topic, ok := r.Topics[topicId]; ok {
+			delta.Topics = append(delta.Topics, topicRow(topic))
+		}
+	}
+
+	for key := range changes.votes {
+		topic, ok := r.Topics[key.TopicID]
+		if !ok {
+			continue
+		}
+
+		points, voted := topic.ClientVotes[key.UserID]
+		delta.Votes = append(delta.Votes, voteRow{
+			TopicID: key.TopicID,
+			UserID:  key.UserID,
+			Points:  points,
+			Deleted: !voted,
+		})
+	}
+
+	for commentId, topicId := range changes.comments {
+		topic, ok := r.Topics[topicId]
+		if !ok {
+			continue
+		}
+
+		for _, comment := range topic.Comments {
+			if comment.CommentID == commentId {
+				delta.Comments = append(delta.Comments, commentRow{TopicID: topicId, Comment: comment})
+			}
+		}
+	}
+
+	for key := range changes.rounds {
+		topic, ok := r.Topics[key.TopicID]
+		if !ok || key.Number < 1 || key.Number > len(topic.Rounds) {
+			continue
+		}
+
+		delta.Rounds = append(delta.Rounds, roundRow{TopicID: key.TopicID, Round: topic.Rounds[key.Number-1]})
+	}
+
+	for userId := range changes.participants {
+		if participant, ok := r.Participants[userId]; ok {
+			delta.Participants = append(delta.Participants, *participant)
+		}
+	}
+
+	return delta
+}
+
+func (r *Room) fullDelta() roomDelta {
+	row := r.roomRow()
+	delta := roomDelta{
+		Full:  true,
+		Stamp: r.nextStamp(),
+		Room:  &row,
+	}
+
+	for _, topic := range r.Topics {
+		delta.Topics = append(delta.Topics, topicRow(topic))
+
+		for userId, points := range topic.ClientVotes {
+			delta.Votes = append(delta.Votes, voteRow{TopicID: topic.TopicID, UserID: userId, Points: points})
+		}
+		for _, comment := range topic.Comments {
+			delta.Comments = append(delta.Comments, commentRow{TopicID: topic.TopicID, Comment: comment})
+		}
+		for _, round := range topic.Rounds {
+			delta.Rounds = append(delta.Rounds, roundRow{TopicID: topic.TopicID, Round: round})
+		}
+	}
+
+	for _, participant := range r.Participants {
+		delta.Participants = append(delta.Participants, *participant)
+	}
+
+	return delta
+}
+
+// markAllChanged makes the next save write the whole room, used when a save failed halfway
+func (r *Room) markAllChanged() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.trackedChanges().all = true
+}
+
+func (r *Room) roomRow() roomRow {
+	row := roomRow{
+		RoomID:     r.RoomID,
+		CreatedAt:  r.CreatedAt,
+		Deck:       Deck{Kind: r.Deck.Kind, Cards: append([]string(nil), r.Deck.Cards...)},
+		TopicOrder: append([]TopicID{}, r.TopicOrder...),
+
+		LastActivityAt: r.LastActivityAt,
+	}
+
+	if r.CurrentTopicID != nil {
+		topicId := *r.CurrentTopicID
+		row.CurrentTopicID = &topicId
+	}
+
+	if r.Timer != nil {
+		timer := *r.Timer
+		row.Timer = &timer
+	}
+
+	if r.ArchivedAt != nil {
+		archivedAt := *r.ArchivedAt
+		row.ArchivedAt = &archivedAt
+	}
+
+	return row
+}
+
+// topicRow copies the columns of the topic, leaving out its votes, comments and rounds
+func topicRow(topic *Topic) Topic {
+	row := *topic
+	row.ClientVotes = nil
+	row.Comments = nil
+	row.Rounds = nil
+
+	return row
+}
+
+// newRoomChangeSet is the change set of a room that was never saved
+func newRoomChangeSet() *changeSet {
+	changes := newChangeSet()
+	changes.all = true
+
+	return changes
+}
@@ -0,0 +1,62 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CopyRooms loads every room from one repo and saves it into another, returning how many were copied.
+// Rooms the other repo already has are replaced.
+func CopyRooms(roomIds []RoomID, from RoomRepo, to RoomRepo) (int, error) {
+	copied := 0
+	for _, roomId := range roomIds {
+		r, err := from.FindRoom(roomId)
+		if err != nil {
+			return copied, fmt.Errorf("loading room %s: %w", roomId, err)
+		}
+		if r == nil {
+			continue
+		}
+
+		existing, err := to.FindRoom(roomId)
+		if err != nil {
+			return copied, fmt.Errorf("loading room %s: %w", roomId, err)
+		}
+
+		err = replaceRoom(to, r, existing)
+		if err != nil {
+			return copied, err
+		}
+		copied++
+	}
+
+	return copied, nil
+}
+
+// replaceRoom saves the room into the repo in place of the existing one, if any. Saving over it would conflict,
+// so it is deleted first and saved back when the room fails to save.
+func replaceRoom(to RoomRepo, r *Room, existing *Room) error {
+	if existing != nil {
+		err := to.DeleteRoom(r.RoomID)
+		if err != nil {
+			return fmt.Errorf("deleting room %s: %w", r.RoomID, err)
+		}
+	}
+
+	// the stores only write what changed since the room was loaded, the room is new to this one
+	r.markAllChanged()
+	err := to.Save(r)
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("saving room %s: %w", r.RoomID, err)
+	if existing != nil {
+		existing.markAllChanged()
+		if restoreErr := to.Save(existing); restoreErr != nil {
+			err = errors.Join(err, fmt.Errorf("restoring room %s: %w", r.RoomID, restoreErr))
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,150 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldCopyRoomsFromSqliteToBolt(t *testing.T) {
+	from, _ := newTestSqliteRepo(t)
+	to := newTestBoltRepo(t)
+
+	rooms := make([]*Room, 0, 3)
+	for i := 0; i < 3; i++ {
+		room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+		room.AddTopic(ulid.Make(), "Topic", "", "")
+		if err := from.Save(&room); err != nil {
+			t.Fatal(err)
+		}
+		rooms = append(rooms, &room)
+	}
+
+	roomIds, err := from.ListRoomIDs()
+	if err != nil || len(roomIds) != 3 {
+		t.Fatalf("Expected 3 rooms listed, got %d", len(roomIds))
+	}
+
+	copied, err := CopyRooms(roomIds, from, to)
+	if err != nil || copied != 3 {
+		t.Fatalf("Expected 3 rooms copied, got %d: %v", copied, err)
+	}
+
+	for _, room := range rooms {
+		loaded, err := to.FindRoom(room.RoomID)
+		if err != nil || loaded == nil || len(loaded.Topics) != 1 {
+			t.Errorf("Room %s not copied", room.RoomID)
+		}
+	}
+
+	// copying again replaces the rooms copied before
+	rooms[0].AddTopic(ulid.Make(), "Added since", "", "")
+	if err := from.Save(rooms[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err = CopyRooms(roomIds, from, to)
+	if err != nil || copied != 3 {
+		t.Fatalf("Expected 3 rooms copied again, got %d: %v", copied, err)
+	}
+
+	loaded, err := to.FindRoom(rooms[0].RoomID)
+	if err != nil || loaded == nil || len(loaded.Topics) != 2 {
+		t.Error("Room not replaced")
+	}
+}
+
+func TestShouldCopyRoomsIntoSqlite(t *testing.T) {
+	from := newTestBoltRepo(t)
+	to, _ := newTestSqliteRepo(t)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	room.AddTopic(ulid.Make(), "Topic", "", "")
+	if err := from.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CopyRooms([]RoomID{room.RoomID}, from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := to.FindRoom(room.RoomID)
+	if err != nil || loaded == nil || len(loaded.Topics) != 1 {
+		t.Error("Room not written in full")
+	}
+}
+
+// failingSaveRepo fails the next save, then saves normally
+type failingSaveRepo struct {
+	RoomRepo
+	fail bool
+}
+
+func (r *failingSaveRepo) Save(room *Room) error {
+	if r.fail {
+		r.fail = false
+		return errors.New("disk full")
+	}
+
+	return r.RoomRepo.Save(room)
+}
+
+func TestShouldReplaceRoomSavedSeparatelyInTarget(t *testing.T) {
+	from := newTestBoltRepo(t)
+	to, _ := newTestSqliteRepo(t)
+
+	roomId := ulid.Make()
+	ours := NewRoom(roomId, make(map[TopicID]*Topic), time.Now())
+	ours.AddTopic(ulid.Make(), "Ours", "", "")
+	if err := from.Save(&ours); err != nil {
+		t.Fatal(err)
+	}
+
+	// the target went through more versions of its own
+	theirs := NewRoom(roomId, make(map[TopicID]*Topic), time.Now())
+	for _, title := range []string{"Theirs", "Also theirs"} {
+		theirs.AddTopic(ulid.Make(), title, "", "")
+		if err := to.Save(&theirs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := CopyRooms([]RoomID{roomId}, from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := to.FindRoom(roomId)
+	if err != nil || loaded == nil || len(loaded.Topics) != 1 || loaded.OrderedTopics()[0].Title != "Ours" {
+		t.Error("Room not replaced")
+	}
+}
+
+func TestShouldKeepTargetRoomWhenCopyFails(t *testing.T) {
+	from := newTestBoltRepo(t)
+	target, _ := newTestSqliteRepo(t)
+	to := &failingSaveRepo{RoomRepo: target}
+
+	roomId := ulid.Make()
+	ours := NewRoom(roomId, make(map[TopicID]*Topic), time.Now())
+	ours.AddTopic(ulid.Make(), "Ours", "", "")
+	if err := from.Save(&ours); err != nil {
+		t.Fatal(err)
+	}
+
+	theirs := NewRoom(roomId, make(map[TopicID]*Topic), time.Now())
+	theirs.AddTopic(ulid.Make(), "Theirs", "", "")
+	if err := to.Save(&theirs); err != nil {
+		t.Fatal(err)
+	}
+
+	to.fail = true
+	if _, err := CopyRooms([]RoomID{roomId}, from, to); err == nil {
+		t.Fatal("Expected the failed save to be reported")
+	}
+
+	loaded, err := to.FindRoom(roomId)
+	if err != nil || loaded == nil || len(loaded.Topics) != 1 || loaded.OrderedTopics()[0].Title != "Theirs" {
+		t.Error("Room of the target lost")
+	}
+}
@@ -0,0 +1,127 @@
+package room
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCard = errors.New("card is not part of the room's deck")
+	ErrInvalidDeck = errors.New("invalid deck")
+)
+
+const (
+	// DeckClassic is the deck rooms had before decks could be chosen, with the card values the web client sends
+	DeckClassic           = "classic"
+	DeckFibonacci         = "fibonacci"
+	DeckModifiedFibonacci = "modified_fibonacci"
+	DeckTShirt            = "tshirt"
+	DeckPowersOfTwo       = "powers_of_two"
+	DeckCustom            = "custom"
+)
+
+const (
+	CardUnknown     = "?"
+	CardCoffeeBreak = "☕"
+	// the classic deck names its special cards instead
+	CardClassicUnknown     = "no_ans"
+	CardClassicCoffeeBreak = "coffee"
+
+	maxCustomCards = 30
+	maxCardLength  = 10
+)
+
+var presetDecks = map[string][]string{
+	DeckClassic:           {"0.5", "1", "2", "3", "5", "8", "13", "20", CardClassicCoffeeBreak, CardClassicUnknown},
+	DeckFibonacci:         {"0", "1", "2", "3", "5", "8", "13", "21", "34", "55", "89", CardUnknown, CardCoffeeBreak},
+	DeckModifiedFibonacci: {"0", "½", "1", "2", "3", "5", "8", "13", "20", "40", "100", CardUnknown, CardCoffeeBreak},
+	DeckTShirt:            {"XS", "S", "M", "L", "XL", "XXL", CardUnknown, CardCoffeeBreak},
+	DeckPowersOfTwo:       {"0", "1", "2", "4", "8", "16", "32", "64", CardUnknown, CardCoffeeBreak},
+}
+
+// Deck is the set of cards participants are allowed to vote with, in ascending order. Cards without a numeric
+// value, like t-shirt sizes, are ordered by their position, so custom decks only have their numeric cards checked.
+type Deck struct {
+	Kind  string   `json:"kind"`
+	Cards []string `json:"cards"`
+}
+
+// NewDeck builds one of the preset decks, or a custom one from the given cards when kind is DeckCustom
+func NewDeck(kind string, cards []string) (Deck, error) {
+	if kind == DeckCustom {
+		return newCustomDeck(cards)
+	}
+
+	preset, ok := presetDecks[kind]
+	if !ok {
+		return Deck{}, ErrInvalidDeck
+	}
+
+	return Deck{
+		Kind:  kind,
+		Cards: append([]string(nil), preset...),
+	}, nil
+}
+
+// DefaultDeck is the deck of rooms created without choosing one, and of rooms saved before decks existed
+func DefaultDeck() Deck {
+	deck, _ := NewDeck(DeckClassic, nil)
+	return deck
+}
+
+func newCustomDeck(cards []string) (Deck, error) {
+	if len(cards) == 0 || len(cards) > maxCustomCards {
+		return Deck{}, ErrInvalidDeck
+	}
+
+	seen := make(map[string]bool)
+	deckCards := make([]string, 0, len(cards))
+	highest, numeric := 0.0, false
+	for _, card := range cards {
+		card = strings.TrimSpace(card)
+		if card == "" || len([]rune(card)) > maxCardLength || seen[card] {
+			return Deck{}, ErrInvalidDeck
+		}
+
+		// near consensus and outliers go by the position of the cards
+		if value, ok := cardValue(card); ok {
+			if numeric && value <= highest {
+				return Deck{}, ErrInvalidDeck
+			}
+			highest, numeric = value, true
+		}
+
+		seen[card] = true
+		deckCards = append(deckCards, card)
+	}
+
+	return Deck{
+		Kind:  DeckCustom,
+		Cards: deckCards,
+	}, nil
+}
+
+func (d Deck) Contains(card string) bool {
+	return d.Index(card) != -1
+}
+
+// Index returns the position of the card in the deck, or -1 when it isn't part of it
+func (d Deck) Index(card string) int {
+	for i, c := range d.Cards {
+		if c == card {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// IsSpecialCard tells if the card is a non-estimate card such as "?" or "☕"
+func IsSpecialCard(card string) bool {
+	switch card {
+	case CardUnknown, CardCoffeeBreak, CardClassicUnknown, CardClassicCoffeeBreak:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldBuildDecks(t *testing.T) {
+	deck, err := NewDeck(DeckTShirt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !deck.Contains("XL") || deck.Contains("5") {
+		t.Error("Wrong cards in t-shirt deck")
+	}
+
+	deck, err = NewDeck(DeckCustom, []string{"1", " 2 ", CardUnknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !deck.Contains("2") || deck.Index(CardUnknown) != 2 {
+		t.Error("Wrong cards in custom deck")
+	}
+
+	if _, err := NewDeck(DeckCustom, []string{"1", "1"}); !errors.Is(err, ErrInvalidDeck) {
+		t.Error("Accepted custom deck with duplicated cards")
+	}
+
+	if _, err := NewDeck(DeckCustom, []string{"1", "5", "3"}); !errors.Is(err, ErrInvalidDeck) {
+		t.Error("Accepted custom deck out of order")
+	}
+
+	if _, err := NewDeck(DeckCustom, []string{"S", "1", CardUnknown, "2", "XL"}); err != nil {
+		t.Error("Rejected custom deck with its numeric cards in order")
+	}
+
+	if _, err := NewDeck(DeckCustom, nil); !errors.Is(err, ErrInvalidDeck) {
+		t.Error("Accepted empty custom deck")
+	}
+
+	if _, err := NewDeck("tarot", nil); !errors.Is(err, ErrInvalidDeck) {
+		t.Error("Accepted unknown deck")
+	}
+}
+
+func TestShouldRejectCardsOutsideDeck(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	if err := room.VoteOnTopic(ulid.Make(), topicId, "banana"); !errors.Is(err, ErrInvalidCard) {
+		t.Error("Accepted vote outside the deck")
+	}
+
+	if err := room.CompleteTopic(topicId, "banana"); !errors.Is(err, ErrInvalidCard) {
+		t.Error("Completed topic with points outside the deck")
+	}
+
+	select {
+	case <-room.BroadcastChan:
+		t.Error("Event dispatched for invalid card")
+	default:
+	}
+}
+
+func TestShouldTrimVotesLikeCustomCards(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	room.Deck, _ = NewDeck(DeckCustom, []string{" 1", "2 "})
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	userId := ulid.Make()
+	if err := room.VoteOnTopic(userId, topicId, " 2 "); err != nil {
+		t.Fatal(err)
+	}
+	if room.Topics[topicId].ClientVotes[userId] != "2" {
+		t.Error("Vote not trimmed")
+	}
+
+	if err := room.CompleteTopic(topicId, "1 "); err != nil || *room.Topics[topicId].Points != "1" {
+		t.Error("Points not trimmed")
+	}
+}
+
+func TestShouldChangeDeck(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	room.VoteOnTopic(ulid.Make(), topicId, "5")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+
+	deck, _ := NewDeck(DeckTShirt, nil)
+	room.ChangeDeck(deck)
+
+	if room.Deck.Kind != DeckTShirt {
+		t.Error("Deck not changed")
+	}
+
+	if len(room.Topics[topicId].ClientVotes) != 0 {
+		t.Error("Kept votes outside the new deck")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		if _, ok := ev.Event.(DeckChangedEvent); !ok {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+}
+
+func TestShouldAcceptWebClientCardsByDefault(t *testing.T) {
+	// saved before rooms had decks
+	var room Room
+	if err := json.Unmarshal([]byte(`{"room_id":"`+ulid.Make().String()+`","topics":{}}`), &room); err != nil {
+		t.Fatal(err)
+	}
+	room.hydrate()
+
+	if room.Deck.Kind != DeckClassic {
+		t.Fatalf("Expected the classic deck, got %s", room.Deck.Kind)
+	}
+
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "", "")
+	for _, card := range []string{"0.5", "20", CardClassicCoffeeBreak, CardClassicUnknown} {
+		if err := room.VoteOnTopic(ulid.Make(), topicId, card); err != nil {
+			t.Errorf("Vote %s refused: %v", card, err)
+		}
+	}
+	if err := room.CompleteTopic(topicId, CardClassicCoffeeBreak); err != nil {
+		t.Errorf("Completing with %s refused: %v", CardClassicCoffeeBreak, err)
+	}
+}
@@ -2,12 +2,19 @@ package room
 
 import (
 	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
 	"time"
 )
 
 type UserJoinedRoom struct {
 	UserID   ulid.ULID `json:"client_id"`
 	Username string    `json:"username"`
+	Role     user.Role `json:"role"`
+}
+
+type UserRoleChangedEvent struct {
+	UserID ulid.ULID `json:"user_id"`
+	Role   user.Role `json:"role"`
 }
 
 type UserLeftRoom struct {
@@ -21,16 +28,24 @@ type UserVotedEvent struct {
 type TopicAddedEvent struct {
 	TopicID     TopicID   `json:"topic_id"`
 	Title       string    `json:"title" json:"title"`
+	Url         string    `json:"url"`
 	Description string    `json:"description" json:"description"`
 	CreatedAt   time.Time `json:"created_at" json:"created_at"`
 }
 
+type TopicsImportedEvent struct {
+	Count  int               `json:"count"`
+	Topics []TopicAddedEvent `json:"topics"`
+}
+
 type TopicRemovedEvent struct {
 	TopicID TopicID `json:"topic_id"`
 }
 
 type TopicVotesResetedEvent struct {
 	TopicID TopicID `json:"topic_id"`
+	Reason  string  `json:"reason"`
+	Round   int     `json:"round"`
 }
 
 type TopicCompletedEvent struct {
@@ -45,6 +60,10 @@ type TopicUpdatedEvent struct {
 	Url     string  `json:"url"`
 }
 
+type TopicsReorderedEvent struct {
+	TopicIDs []TopicID `json:"topic_ids"`
+}
+
 type CurrentTopicChangedEvent struct {
 	TopicID TopicID `json:"topic_id"`
 }
@@ -55,10 +74,48 @@ type CommentAddedEvent struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type DeckChangedEvent struct {
+	Deck Deck `json:"deck"`
+}
+
 type VisibilityToggled struct {
+	TopicID TopicID    `json:"topic_id"`
+	Visible bool       `json:"visible"`
+	Stats   *VoteStats `json:"stats"`
+}
+
+type TimerStartedEvent struct {
+	TopicID     TopicID   `json:"topic_id"`
+	Deadline    time.Time `json:"deadline"`
+	RemainingMs int64     `json:"remaining_ms"`
+	AutoReveal  bool      `json:"auto_reveal"`
+}
+
+type TimerPausedEvent struct {
+	TopicID     TopicID `json:"topic_id"`
+	RemainingMs int64   `json:"remaining_ms"`
+}
+
+type TimerExtendedEvent struct {
+	TopicID     TopicID    `json:"topic_id"`
+	Deadline    *time.Time `json:"deadline"`
+	RemainingMs int64      `json:"remaining_ms"`
+}
+
+type TimerCancelledEvent struct {
 	TopicID TopicID `json:"topic_id"`
 }
 
+type TimerTickEvent struct {
+	TopicID     TopicID `json:"topic_id"`
+	RemainingMs int64   `json:"remaining_ms"`
+}
+
+type TimerExpiredEvent struct {
+	TopicID    TopicID `json:"topic_id"`
+	AutoReveal bool    `json:"auto_reveal"`
+}
+
 type Auth struct {
 	ClientID ulid.ULID `json:"client_id"`
 	Username string    `json:"username"`
@@ -0,0 +1,200 @@
+package room
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"planning-poker/internal/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report is the summary of a planning session, with every topic in rank order
+type Report struct {
+	RoomID      RoomID        `json:"room_id"`
+	CreatedAt   time.Time     `json:"created_at"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Deck        string        `json:"deck"`
+	Topics      []ReportTopic `json:"topics"`
+}
+
+type ReportTopic struct {
+	TopicID      TopicID        `json:"topic_id"`
+	Title        string         `json:"title"`
+	Url          string         `json:"url"`
+	Points       *string        `json:"points"`
+	Completed    bool           `json:"completed"`
+	CompletedAt  *time.Time     `json:"completed_at"`
+	Rounds       int            `json:"rounds"`
+	Votes        []ReportVote   `json:"votes"`
+	Distribution map[string]int `json:"distribution"`
+	Comments     []string       `json:"comments"`
+}
+
+type ReportVote struct {
+	UserID user.UserID `json:"user_id"`
+	Name   string      `json:"name"`
+	Points string      `json:"points"`
+}
+
+func (r *Room) Report(generatedAt time.Time) Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := Report{
+		RoomID:      r.RoomID,
+		CreatedAt:   r.CreatedAt,
+		GeneratedAt: generatedAt,
+		Deck:        r.Deck.Kind,
+		Topics:      make([]ReportTopic, 0, len(r.Topics)),
+	}
+
+	for _, topic := range r.orderedTopics() {
+		votes := make([]ReportVote, 0, len(topic.ClientVotes))
+		distribution := make(map[string]int)
+		for userId, points := range r.voterVotes(topic) {
+			name := "Anonymous"
+			if participant, ok := r.Participants[userId]; ok {
+				name = participant.Name
+			}
+
+			votes = append(votes, ReportVote{UserID: userId, Name: name, Points: points})
+			distribution[points]++
+		}
+		sort.Slice(votes, func(i, j int) bool {
+			return votes[i].Name < votes[j].Name
+		})
+
+		comments := make([]string, 0, len(topic.Comments))
+		for _, comment := range topic.Comments {
+			comments = append(comments, comment.Content)
+		}
+
+		report.Topics = append(report.Topics, ReportTopic{
+			TopicID:      topic.TopicID,
+			Title:        topic.Title,
+			Url:          topic.Url,
+			Points:       topic.Points,
+			Completed:    topic.Completed,
+			CompletedAt:  topic.CompletedAt,
+			Rounds:       len(topic.VotingRounds()),
+			Votes:        votes,
+			Distribution: distribution,
+			Comments:     comments,
+		})
+	}
+
+	return report
+}
+
+func (r Report) WriteCSV(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+
+	err := csvWriter.Write([]string{"title", "url", "points", "completed_at", "rounds", "votes", "comments"})
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range r.Topics {
+		err := csvWriter.Write([]string{
+			topic.Title,
+			topic.Url,
+			topic.points(),
+			topic.completedAt(time.RFC3339),
+			fmt.Sprint(topic.Rounds),
+			topic.voteBreakdown("; "),
+			strings.Join(topic.Comments, " | "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+// WriteMarkdown writes the report as a table ready to be pasted into sprint notes
+func (r Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+
+	completed := 0
+	for _, topic := range r.Topics {
+		if topic.Completed {
+			completed++
+		}
+	}
+
+	fmt.Fprintf(&b, "# Planning poker estimates\n\n")
+	fmt.Fprintf(&b, "%d of %d topics estimated, exported on %s.\n\n", completed, len(r.Topics), r.GeneratedAt.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&b, "| # | Topic | Points | Completed | Votes |\n")
+	fmt.Fprintf(&b, "|---|---|---|---|---|\n")
+
+	for i, topic := range r.Topics {
+		title := escapeMarkdownCell(topic.Title)
+		if topic.Url != "" {
+			title = fmt.Sprintf("[%s](%s)", title, escapeMarkdownCell(topic.Url))
+		}
+
+		points := topic.points()
+		if points == "" {
+			points = "-"
+		}
+
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %s |\n",
+			i+1,
+			title,
+			escapeMarkdownCell(points),
+			topic.completedAt("2006-01-02 15:04"),
+			escapeMarkdownCell(topic.voteBreakdown(", ")),
+		)
+	}
+
+	for _, topic := range r.Topics {
+		if len(topic.Comments) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n## %s\n\n", topic.Title)
+		for _, comment := range topic.Comments {
+			fmt.Fprintf(&b, "- %s\n", strings.ReplaceAll(comment, "\n", " "))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func (t ReportTopic) points() string {
+	if t.Points == nil {
+		return ""
+	}
+
+	return *t.Points
+}
+
+func (t ReportTopic) completedAt(layout string) string {
+	if t.CompletedAt == nil {
+		return ""
+	}
+
+	return t.CompletedAt.Format(layout)
+}
+
+func (t ReportTopic) voteBreakdown(separator string) string {
+	votes := make([]string, 0, len(t.Votes))
+	for _, vote := range t.Votes {
+		votes = append(votes, vote.Name+": "+vote.Points)
+	}
+
+	return strings.Join(votes, separator)
+}
+
+func escapeMarkdownCell(value string) string {
+	value = strings.ReplaceAll(value, "\n", " ")
+
+	return strings.ReplaceAll(value, "|", "\\|")
+}
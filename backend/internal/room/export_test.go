@@ -0,0 +1,77 @@
+package room
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEstimatedRoom() *Room {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+
+	alice := user.NewUser(ulid.Make(), "Alice")
+	bob := user.NewUser(ulid.Make(), "Bob")
+	room.Join(alice)
+	room.Join(bob)
+
+	done := ulid.Make()
+	room.AddTopic(done, "Login | signup", "https://example.com/1", "")
+	room.VoteOnTopic(bob.UserID, done, "8")
+	room.VoteOnTopic(alice.UserID, done, "5")
+	room.AddComment(ulid.Make(), done, "needs design")
+	room.CompleteTopic(done, "8")
+
+	room.AddTopic(ulid.Make(), "Pending", "", "")
+
+	return &room
+}
+
+func TestShouldBuildReport(t *testing.T) {
+	room := newEstimatedRoom()
+
+	report := room.Report(time.Now())
+
+	if len(report.Topics) != 2 || report.Topics[0].Title != "Login | signup" {
+		t.Fatal("Topics not reported in order")
+	}
+
+	done := report.Topics[0]
+	if *done.Points != "8" || done.CompletedAt == nil || len(done.Comments) != 1 {
+		t.Error("Wrong topic report")
+	}
+
+	if len(done.Votes) != 2 || done.Votes[0].Name != "Alice" || done.Votes[1].Points != "8" || done.Distribution["5"] != 1 {
+		t.Errorf("Wrong vote breakdown: %+v", done.Votes)
+	}
+}
+
+func TestShouldWriteReportFormats(t *testing.T) {
+	report := newEstimatedRoom().Report(time.Now())
+
+	var csvOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 || records[1][2] != "8" || records[1][5] != "Alice: 5; Bob: 8" || records[2][2] != "" {
+		t.Errorf("Wrong csv report: %v", records)
+	}
+
+	var md bytes.Buffer
+	if err := report.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(md.String(), `| 1 | [Login \| signup](https://example.com/1) | 8 |`) || !strings.Contains(md.String(), "- needs design") {
+		t.Errorf("Wrong markdown report:\n%s", md.String())
+	}
+}
@@ -0,0 +1,198 @@
+package room
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	maxImportedTopics    = 500
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+)
+
+var ErrEmptyImport = errors.New("no topics to import")
+
+// TopicDraft is a topic waiting to be imported into a room
+type TopicDraft struct {
+	TopicID     TopicID `json:"-"`
+	Title       string  `json:"title"`
+	Url         string  `json:"url"`
+	Description string  `json:"description"`
+}
+
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// ImportError lists every row that failed validation, nothing gets imported when it is returned
+type ImportError struct {
+	Rows []RowError
+}
+
+func (e *ImportError) Error() string {
+	messages := make([]string, 0, len(e.Rows))
+	for _, row := range e.Rows {
+		messages = append(messages, fmt.Sprintf("row %d: %s", row.Row, row.Message))
+	}
+
+	return "invalid topics: " + strings.Join(messages, "; ")
+}
+
+// ParseTopicsCSV reads title, url and description columns. The header row is optional,
+// when present the columns can come in any order.
+func ParseTopicsCSV(reader io.Reader) ([]TopicDraft, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{"title": 0, "url": 1, "description": 2}
+	if len(records) > 0 && isHeader(records[0]) {
+		columns = make(map[string]int)
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		records = records[1:]
+	}
+
+	column := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	drafts := make([]TopicDraft, 0, len(records))
+	for _, record := range records {
+		drafts = append(drafts, TopicDraft{
+			Title:       column(record, "title"),
+			Url:         column(record, "url"),
+			Description: column(record, "description"),
+		})
+	}
+
+	return drafts, nil
+}
+
+// isHeader tells if the record only has column names, one of them being the title
+func isHeader(record []string) bool {
+	hasTitle := false
+	for _, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "title":
+			hasTitle = true
+		case "url", "description", "":
+		default:
+			return false
+		}
+	}
+
+	return hasTitle
+}
+
+// ParseTopicsJSON reads an array of objects with title, url and description fields
+func ParseTopicsJSON(reader io.Reader) ([]TopicDraft, error) {
+	var drafts []TopicDraft
+	err := json.NewDecoder(reader).Decode(&drafts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range drafts {
+		drafts[i].Title = strings.TrimSpace(drafts[i].Title)
+		drafts[i].Url = strings.TrimSpace(drafts[i].Url)
+		drafts[i].Description = strings.TrimSpace(drafts[i].Description)
+	}
+
+	return drafts, nil
+}
+
+// ValidateTopicDrafts checks every draft and reports all the invalid rows at once, rows start at 1
+func ValidateTopicDrafts(drafts []TopicDraft) error {
+	if len(drafts) == 0 {
+		return ErrEmptyImport
+	}
+
+	if len(drafts) > maxImportedTopics {
+		return &ImportError{Rows: []RowError{{
+			Row:     maxImportedTopics + 1,
+			Message: fmt.Sprintf("can't import more than %d topics at once", maxImportedTopics),
+		}}}
+	}
+
+	var rows []RowError
+	for i, draft := range drafts {
+		if message := validateDraft(draft); message != "" {
+			rows = append(rows, RowError{Row: i + 1, Message: message})
+		}
+	}
+
+	if len(rows) > 0 {
+		return &ImportError{Rows: rows}
+	}
+
+	return nil
+}
+
+func validateDraft(draft TopicDraft) string {
+	if draft.Title == "" {
+		return "title is required"
+	}
+
+	if len([]rune(draft.Title)) > maxTitleLength {
+		return fmt.Sprintf("title is longer than %d characters", maxTitleLength)
+	}
+
+	if len([]rune(draft.Description)) > maxDescriptionLength {
+		return fmt.Sprintf("description is longer than %d characters", maxDescriptionLength)
+	}
+
+	if draft.Url != "" {
+		u, err := url.Parse(draft.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "url must be an absolute http(s) url"
+		}
+	}
+
+	return ""
+}
+
+// ImportTopics validates every draft and adds them all to the room, or none of them when any is invalid.
+// Clients get a single TopicsImportedEvent with every added topic instead of one frame per topic.
+func (r *Room) ImportTopics(drafts []TopicDraft) error {
+	err := ValidateTopicDrafts(drafts)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	added := make([]TopicAddedEvent, 0, len(drafts))
+	for _, draft := range drafts {
+		added = append(added, r.addTopic(draft.TopicID, draft.Title, draft.Url, draft.Description, now))
+	}
+
+	event := TopicsImportedEvent{
+		Count:  len(added),
+		Topics: added,
+	}
+	r.record(now, eventTopicsImported, event)
+
+	r.BroadcastEvent(event)
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldParseTopicsCSV(t *testing.T) {
+	withHeader := "description,title,url\nsome desc,First,https://example.com/1\n,Second,\n"
+	drafts, err := ParseTopicsCSV(strings.NewReader(withHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(drafts) != 2 || drafts[0].Title != "First" || drafts[0].Url != "https://example.com/1" || drafts[0].Description != "some desc" {
+		t.Errorf("Wrong drafts parsed: %+v", drafts)
+	}
+
+	withoutHeader := "Only title\nWith url,https://example.com\n"
+	drafts, err = ParseTopicsCSV(strings.NewReader(withoutHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(drafts) != 2 || drafts[0].Title != "Only title" || drafts[1].Url != "https://example.com" {
+		t.Errorf("Wrong drafts parsed: %+v", drafts)
+	}
+}
+
+func TestShouldParseTopicsJSON(t *testing.T) {
+	drafts, err := ParseTopicsJSON(strings.NewReader(`[{"title": " First ", "url": "https://example.com"}, {"title": "Second", "description": "desc"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(drafts) != 2 || drafts[0].Title != "First" || drafts[1].Description != "desc" {
+		t.Errorf("Wrong drafts parsed: %+v", drafts)
+	}
+}
+
+func TestShouldImportTopicsAtomically(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+
+	err := room.ImportTopics([]TopicDraft{
+		{TopicID: ulid.Make(), Title: "Valid"},
+		{TopicID: ulid.Make(), Title: ""},
+		{TopicID: ulid.Make(), Title: "Bad url", Url: "ftp://example.com"},
+	})
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || len(importErr.Rows) != 2 || importErr.Rows[0].Row != 2 || importErr.Rows[1].Row != 3 {
+		t.Fatalf("Wrong validation errors: %v", err)
+	}
+
+	if len(room.Topics) != 0 {
+		t.Error("Imported topics from an invalid batch")
+	}
+
+	drafts := []TopicDraft{
+		{TopicID: ulid.Make(), Title: "First"},
+		{TopicID: ulid.Make(), Title: "Second", Url: "https://example.com"},
+	}
+	if err := room.ImportTopics(drafts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(room.Topics) != 2 || room.TopicOrder[0] != drafts[0].TopicID || room.TopicOrder[1] != drafts[1].TopicID {
+		t.Error("Topics not imported in order")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		imported, ok := ev.Event.(TopicsImportedEvent)
+		if !ok || imported.Count != 2 || len(imported.Topics) != 2 {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+
+	select {
+	case <-room.BroadcastChan:
+		t.Error("Dispatched more than the summary event")
+	default:
+	}
+
+	if err := room.ImportTopics(nil); !errors.Is(err, ErrEmptyImport) {
+		t.Error("Accepted empty import")
+	}
+}
@@ -0,0 +1,321 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"planning-poker/internal/user"
+	"time"
+)
+
+// Every change made to a room is also recorded in its journal, with whatever was needed to make it.
+// Broadcast events are shaped for clients and leave some of it out (votes stay hidden until they are
+// revealed), so recorded events carry the arguments of the room method instead, and replaying one calls
+// that method again at the time it was first made.
+
+const (
+	eventUserJoined          = "USER_JOINED"
+	eventUserRoleChanged     = "USER_ROLE_CHANGED"
+	eventTopicAdded          = "TOPIC_ADDED"
+	eventTopicsImported      = "TOPICS_IMPORTED"
+	eventTopicRemoved        = "TOPIC_REMOVED"
+	eventTopicCompleted      = "TOPIC_COMPLETED"
+	eventTopicReset          = "TOPIC_RESET"
+	eventTopicUpdated        = "TOPIC_UPDATED"
+	eventTopicVoted          = "TOPIC_VOTED"
+	eventVisibilityToggled   = "VISIBILITY_TOGGLED"
+	eventCurrentTopicChanged = "CURRENT_TOPIC_CHANGED"
+	eventCommentAdded        = "COMMENT_ADDED"
+	eventTopicsReordered     = "TOPICS_REORDERED"
+	eventDeckChanged         = "DECK_CHANGED"
+	eventTimerStarted        = "TIMER_STARTED"
+	eventTimerPaused         = "TIMER_PAUSED"
+	eventTimerResumed        = "TIMER_RESUMED"
+	eventTimerExtended       = "TIMER_EXTENDED"
+	eventTimerCancelled      = "TIMER_CANCELLED"
+	eventTimerExpired        = "TIMER_EXPIRED"
+	eventRoomArchived        = "ROOM_ARCHIVED"
+)
+
+// journalLimit bounds the events a room keeps until they are saved. Instances that never save a room
+// drop the oldest ones, a repo missing some of them stores the whole room instead.
+const journalLimit = 1024
+
+var ErrJournalGap = errors.New("recorded event doesn't follow the last one applied to the room")
+
+// RecordedEvent is a change made to a room, numbered from the first change made after it was created
+type RecordedEvent struct {
+	Number uint64          `json:"number"`
+	Type   string          `json:"type"`
+	At     time.Time       `json:"at"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type joinRecord struct {
+	UserID user.UserID `json:"user_id"`
+	Name   string      `json:"name"`
+	Role   user.Role   `json:"role"`
+}
+
+type voteRecord struct {
+	TopicID TopicID     `json:"topic_id"`
+	UserID  user.UserID `json:"user_id"`
+	Points  string      `json:"points"`
+}
+
+type topicRecord struct {
+	TopicID TopicID `json:"topic_id"`
+}
+
+type commentRecord struct {
+	TopicID   TopicID   `json:"topic_id"`
+	CommentID CommentID `json:"comment_id"`
+	Content   string    `json:"content"`
+}
+
+type timerRecord struct {
+	Duration   time.Duration `json:"duration"`
+	AutoReveal bool          `json:"auto_reveal"`
+}
+
+// now is the time changes are made at, which is the time they were first made while replaying them
+func (r *Room) now() time.Time {
+	if r.replaying {
+		return r.replayAt
+	}
+
+	return time.Now()
+}
+
+// record appends a change to the journal, must be called with the room lock held once the change is made
+func (r *Room) record(at time.Time, eventType string, data interface{}) {
+	r.recorded++
+	r.markActivity(at, eventType)
+	if r.replaying {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		// every recorded type marshals, this can't happen
+		panic(err)
+	}
+
+	if len(r.journal) == journalLimit {
+		r.journal = append(r.journal[:0:0], r.journal[1:]...)
+	}
+	r.journal = append(r.journal, RecordedEvent{
+		Number: r.recorded,
+		Type:   eventType,
+		At:     at,
+		Data:   payload,
+	})
+}
+
+// Recorded returns the number of the last change made to the room
+func (r *Room) Recorded() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.recorded
+}
+
+// journalBatch is what a room recorded since its journal was last taken
+type journalBatch struct {
+	Events []RecordedEvent
+	// Recorded is the number of the last change made to the room
+	Recorded uint64
+	// State is the room as of Recorded, only set when asked for
+	State json.RawMessage
+	Stamp saveStamp
+}
+
+// takeJournal empties the journal. withState decides from the batch whether the room state is needed as well,
+// it is called with the room lock held so the state matches the events.
+func (r *Room) takeJournal(withState func(batch journalBatch) bool) (journalBatch, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	batch := journalBatch{
+		Events:   r.journal,
+		Recorded: r.recorded,
+		Stamp:    r.nextStamp(),
+	}
+	r.journal = nil
+
+	if withState(batch) {
+		state, err := json.Marshal(r)
+		if err != nil {
+			return journalBatch{}, err
+		}
+		batch.State = state
+	}
+
+	return batch, nil
+}
+
+// restoreJournal puts back events taken from the journal that couldn't be saved
+func (r *Room) restoreJournal(events []RecordedEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	journal := append(events, r.journal...)
+	if len(journal) > journalLimit {
+		journal = journal[len(journal)-journalLimit:]
+	}
+	r.journal = journal
+}
+
+// Replay applies recorded events to the room, which must be the room as it was before the first of them.
+// Nothing is broadcast while replaying and the events aren't recorded again.
+func (r *Room) Replay(events []RecordedEvent) error {
+	r.replaying = true
+	defer func() {
+		r.replaying = false
+		r.changes = newChangeSet()
+	}()
+
+	for _, ev := range events {
+		if ev.Number != r.recorded+1 {
+			return fmt.Errorf("%w: got %d after %d", ErrJournalGap, ev.Number, r.recorded)
+		}
+
+		r.replayAt = ev.At
+		err := r.applyRecorded(ev)
+		if err != nil {
+			return fmt.Errorf("replaying %s event %d: %w", ev.Type, ev.Number, err)
+		}
+
+		if r.recorded != ev.Number {
+			return fmt.Errorf("replaying %s event %d: it didn't change the room", ev.Type, ev.Number)
+		}
+	}
+
+	return nil
+}
+
+func (r *Room) applyRecorded(ev RecordedEvent) error {
+	switch ev.Type {
+	case eventUserJoined:
+		var data joinRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		r.Join(user.User{UserID: data.UserID, Name: data.Name, Role: data.Role})
+		return nil
+	case eventUserRoleChanged:
+		var data UserRoleChangedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.SetUserRole(data.UserID, data.Role)
+	case eventTopicAdded:
+		var data TopicAddedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		r.AddTopic(data.TopicID, data.Title, data.Url, data.Description)
+		return nil
+	case eventTopicsImported:
+		var data TopicsImportedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		drafts := make([]TopicDraft, 0, len(data.Topics))
+		for _, topic := range data.Topics {
+			drafts = append(drafts, TopicDraft{TopicID: topic.TopicID, Title: topic.Title, Url: topic.Url, Description: topic.Description})
+		}
+		return r.ImportTopics(drafts)
+	case eventTopicRemoved:
+		var data TopicRemovedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.RemoveTopic(data.TopicID)
+	case eventTopicCompleted:
+		var data TopicCompletedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.CompleteTopic(data.TopicID, data.Points)
+	case eventTopicReset:
+		var data TopicVotesResetedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.ResetTopic(data.TopicID, data.Reason)
+	case eventTopicUpdated:
+		var data TopicUpdatedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.ChangeTopicDetails(data.TopicID, data.Title, data.Desc, data.Url)
+	case eventTopicVoted:
+		var data voteRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.VoteOnTopic(data.UserID, data.TopicID, data.Points)
+	case eventVisibilityToggled:
+		var data topicRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.ToggleVisibility(data.TopicID)
+	case eventCurrentTopicChanged:
+		var data CurrentTopicChangedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.SetCurrentTopic(data.TopicID)
+	case eventCommentAdded:
+		var data commentRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.AddComment(data.CommentID, data.TopicID, data.Content)
+	case eventTopicsReordered:
+		var data TopicsReorderedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.ReorderTopics(data.TopicIDs)
+	case eventDeckChanged:
+		var data DeckChangedEvent
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		r.ChangeDeck(data.Deck)
+		return nil
+	case eventTimerStarted:
+		var data timerRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.StartTimer(data.Duration, data.AutoReveal)
+	case eventTimerPaused:
+		return r.PauseTimer()
+	case eventTimerResumed:
+		return r.ResumeTimer()
+	case eventTimerExtended:
+		var data timerRecord
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		return r.ExtendTimer(data.Duration)
+	case eventTimerCancelled:
+		return r.CancelTimer()
+	case eventTimerExpired:
+		var data Timer
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return err
+		}
+		r.ExpireTimer(data)
+		return nil
+	case eventRoomArchived:
+		r.Archive()
+		return nil
+	}
+
+	return fmt.Errorf("unknown event type %s", ev.Type)
+}
@@ -0,0 +1,133 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+// playSession makes every kind of change to the room
+func playSession(t *testing.T, room *Room) {
+	facilitator := user.NewUser(ulid.Make(), "Alice")
+	voter := user.NewUser(ulid.Make(), "Bob")
+	room.Join(facilitator)
+	room.Join(voter)
+
+	first, second := ulid.Make(), ulid.Make()
+	room.AddTopic(first, "Login page", "", "")
+	room.AddTopic(second, "Signup page", "", "")
+
+	steps := []error{
+		room.SetCurrentTopic(first),
+		room.VoteOnTopic(facilitator.UserID, first, "5"),
+		room.VoteOnTopic(voter.UserID, first, "8"),
+		room.StartTimer(time.Minute, true),
+		room.ExtendTimer(30 * time.Second),
+		room.PauseTimer(),
+		room.ResumeTimer(),
+		room.ToggleVisibility(first),
+		room.ResetTopic(first, "too far apart"),
+		room.VoteOnTopic(voter.UserID, first, "5"),
+		room.AddComment(ulid.Make(), first, "agreed"),
+		room.StartTimer(time.Second, true),
+	}
+
+	expired := room.TickTimer(time.Now().Add(time.Minute))
+	if expired == nil || !room.ExpireTimer(*expired) {
+		t.Fatal("Timer didn't expire")
+	}
+
+	steps = append(steps,
+		room.CompleteTopic(first, "5"),
+		room.ImportTopics([]TopicDraft{{TopicID: ulid.Make(), Title: "Imported"}}),
+		room.MoveTopic(second, 0),
+		room.ChangeTopicDetails(second, "Signup flow", "", ""),
+		room.SetUserRole(voter.UserID, user.RoleObserver),
+		room.RemoveTopic(first),
+	)
+	room.ChangeDeck(Deck{Kind: DeckTShirt, Cards: []string{"S", "M", "L"}})
+	room.Join(user.NewUser(voter.UserID, "Bobby"))
+
+	if _, err := room.NextTopic(); err != nil {
+		steps = append(steps, err)
+	}
+	steps = append(steps, room.StartTimer(time.Minute, false), room.CancelTimer())
+	room.Archive()
+
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("Step %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestShouldReplayRecordedEvents(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	initial, err := json.Marshal(&room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playSession(t, &room)
+
+	batch, err := room.takeJournal(func(journalBatch) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Recorded != uint64(len(batch.Events)) || batch.Events[0].Number != 1 {
+		t.Fatalf("Wrong events recorded: %d of %d", len(batch.Events), batch.Recorded)
+	}
+
+	var replayed Room
+	if err := json.Unmarshal(initial, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	replayed.hydrate()
+
+	if err := replayed.Replay(batch.Events); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := json.Marshal(&room)
+	actual, _ := json.Marshal(&replayed)
+	if string(expected) != string(actual) {
+		t.Errorf("Replayed room differs\nexpected %s\ngot      %s", expected, actual)
+	}
+
+	if replayed.LastSeq() != 0 || len(replayed.BroadcastChan) != 0 {
+		t.Error("Events broadcast while replaying")
+	}
+	if replayed.Recorded() != room.Recorded() || len(replayed.journal) != 0 {
+		t.Error("Replayed events recorded again")
+	}
+}
+
+func TestShouldRefuseGapsInReplayedEvents(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	room.AddTopic(ulid.Make(), "First", "", "")
+	room.AddTopic(ulid.Make(), "Second", "", "")
+	batch, _ := room.takeJournal(func(journalBatch) bool { return false })
+
+	empty := NewRoom(room.RoomID, make(map[TopicID]*Topic), room.CreatedAt)
+	if err := empty.Replay(batch.Events[1:]); !errors.Is(err, ErrJournalGap) {
+		t.Errorf("Expected ErrJournalGap, got %v", err)
+	}
+}
+
+func TestShouldBoundJournal(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Topic", "", "")
+
+	for i := 0; i < journalLimit+10; i++ {
+		room.VoteOnTopic(ulid.Make(), topicId, "1")
+		<-room.BroadcastChan
+	}
+
+	if len(room.journal) != journalLimit || room.journal[journalLimit-1].Number != room.Recorded() {
+		t.Errorf("Journal not bounded: %d events", len(room.journal))
+	}
+}
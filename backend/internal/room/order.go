@@ -0,0 +1,181 @@
+package room
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrInvalidOrder = errors.New("invalid topic order")
+	ErrNoTopicsLeft = errors.New("no uncompleted topics left")
+)
+
+// OrderedTopics returns the topics of the room in rank order
+func (r *Room) OrderedTopics() []*Topic {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.orderedTopics()
+}
+
+func (r *Room) orderedTopics() []*Topic {
+	topics := make([]*Topic, 0, len(r.TopicOrder))
+	for _, topicId := range r.TopicOrder {
+		if topic, ok := r.Topics[topicId]; ok {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// ReorderTopics replaces the whole ranking, topicIds must contain every topic of the room exactly once
+func (r *Room) ReorderTopics(topicIds []TopicID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(topicIds) != len(r.Topics) {
+		return ErrInvalidOrder
+	}
+
+	seen := make(map[TopicID]bool)
+	for _, topicId := range topicIds {
+		if _, ok := r.Topics[topicId]; !ok || seen[topicId] {
+			return ErrInvalidOrder
+		}
+		seen[topicId] = true
+	}
+
+	r.TopicOrder = append([]TopicID(nil), topicIds...)
+	r.touchRoom()
+	r.recordOrder()
+
+	r.broadcastOrder()
+
+	return nil
+}
+
+// MoveTopic moves a single topic to the given zero based position of the ranking
+func (r *Room) MoveTopic(topicId TopicID, position int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.Topics[topicId]; !ok {
+		return ErrTopicNotFound
+	}
+
+	if position < 0 || position >= len(r.TopicOrder) {
+		return ErrInvalidOrder
+	}
+
+	r.removeFromOrder(topicId)
+
+	order := make([]TopicID, 0, len(r.TopicOrder)+1)
+	order = append(order, r.TopicOrder[:position]...)
+	order = append(order, topicId)
+	order = append(order, r.TopicOrder[position:]...)
+	r.TopicOrder = order
+	r.touchRoom()
+	r.recordOrder()
+
+	r.broadcastOrder()
+
+	return nil
+}
+
+// NextTopic makes the next uncompleted topic after the current one, in rank order, the current topic
+func (r *Room) NextTopic() (TopicID, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	start := 0
+	if r.CurrentTopicID != nil {
+		for i, topicId := range r.TopicOrder {
+			if topicId == *r.CurrentTopicID {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	// look after the current topic first and wrap around to the ones before it
+	for i := 0; i < len(r.TopicOrder); i++ {
+		topic, ok := r.Topics[r.TopicOrder[(start+i)%len(r.TopicOrder)]]
+		if !ok || topic.Completed {
+			continue
+		}
+
+		if r.CurrentTopicID != nil && *r.CurrentTopicID == topic.TopicID {
+			continue
+		}
+
+		r.setCurrentTopic(topic)
+		r.record(r.now(), eventCurrentTopicChanged, CurrentTopicChangedEvent{TopicID: topic.TopicID})
+
+		return topic.TopicID, nil
+	}
+
+	return TopicID{}, ErrNoTopicsLeft
+}
+
+// recordOrder records the whole ranking, so moving a single topic replays the same way as reordering all of them
+func (r *Room) recordOrder() {
+	r.record(r.now(), eventTopicsReordered, TopicsReorderedEvent{
+		TopicIDs: append([]TopicID(nil), r.TopicOrder...),
+	})
+}
+
+func (r *Room) broadcastOrder() {
+	r.BroadcastEvent(TopicsReorderedEvent{
+		TopicIDs: append([]TopicID(nil), r.TopicOrder...),
+	})
+}
+
+func (r *Room) removeFromOrder(topicId TopicID) {
+	for i, id := range r.TopicOrder {
+		if id == topicId {
+			r.TopicOrder = append(r.TopicOrder[:i:i], r.TopicOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+// repairOrder makes sure every topic shows up exactly once in the ranking. Rooms saved before
+// topics had a rank get them ordered by creation.
+func (r *Room) repairOrder() {
+	seen := make(map[TopicID]bool)
+	order := make([]TopicID, 0, len(r.Topics))
+	for _, topicId := range r.TopicOrder {
+		if _, ok := r.Topics[topicId]; ok && !seen[topicId] {
+			seen[topicId] = true
+			order = append(order, topicId)
+		}
+	}
+
+	var missing []TopicID
+	for topicId := range r.Topics {
+		if !seen[topicId] {
+			missing = append(missing, topicId)
+		}
+	}
+
+	r.TopicOrder = append(order, sortedByCreation(missing)...)
+}
+
+func initialOrder(topics map[TopicID]*Topic) []TopicID {
+	topicIds := make([]TopicID, 0, len(topics))
+	for topicId := range topics {
+		topicIds = append(topicIds, topicId)
+	}
+
+	return sortedByCreation(topicIds)
+}
+
+// sortedByCreation sorts topic ids by their ULID, which starts with the creation time
+func sortedByCreation(topicIds []TopicID) []TopicID {
+	sort.Slice(topicIds, func(i, j int) bool {
+		return topicIds[i].Compare(topicIds[j]) < 0
+	})
+
+	return topicIds
+}
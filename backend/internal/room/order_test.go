@@ -0,0 +1,105 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func newRoomWithTopics(count int) (*Room, []TopicID) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+
+	topicIds := make([]TopicID, 0, count)
+	for i := 0; i < count; i++ {
+		topicId := ulid.Make()
+		room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+		_ = <-room.BroadcastChan // discard TopicCreatedEvent
+		topicIds = append(topicIds, topicId)
+	}
+
+	return &room, topicIds
+}
+
+func TestShouldReorderTopics(t *testing.T) {
+	room, topicIds := newRoomWithTopics(3)
+
+	err := room.ReorderTopics([]TopicID{topicIds[2], topicIds[0], topicIds[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ordered := room.OrderedTopics()
+	if ordered[0].TopicID != topicIds[2] || ordered[1].TopicID != topicIds[0] || ordered[2].TopicID != topicIds[1] {
+		t.Error("Topics not reordered")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		if _, ok := ev.Event.(TopicsReorderedEvent); !ok {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+
+	if err := room.ReorderTopics([]TopicID{topicIds[0], topicIds[0], topicIds[1]}); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("Accepted duplicated topics")
+	}
+
+	if err := room.ReorderTopics(topicIds[:2]); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("Accepted order missing topics")
+	}
+}
+
+func TestShouldMoveTopic(t *testing.T) {
+	room, topicIds := newRoomWithTopics(3)
+
+	room.MoveTopic(topicIds[0], 2)
+
+	if room.TopicOrder[0] != topicIds[1] || room.TopicOrder[1] != topicIds[2] || room.TopicOrder[2] != topicIds[0] {
+		t.Error("Topic not moved")
+	}
+
+	if err := room.MoveTopic(topicIds[0], 3); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("Moved topic out of range")
+	}
+}
+
+func TestShouldAdvanceToNextTopic(t *testing.T) {
+	room, topicIds := newRoomWithTopics(3)
+
+	room.CompleteTopic(topicIds[1], "5")
+	_ = <-room.BroadcastChan // discard TopicCompletedEvent
+
+	next, err := room.NextTopic()
+	if err != nil || next != topicIds[0] {
+		t.Fatal("Didn't start from the first topic")
+	}
+	_ = <-room.BroadcastChan // discard CurrentTopicChangedEvent
+
+	// skips the completed topic
+	next, err = room.NextTopic()
+	if err != nil || next != topicIds[2] || *room.CurrentTopicID != topicIds[2] {
+		t.Fatal("Didn't skip the completed topic")
+	}
+	_ = <-room.BroadcastChan // discard CurrentTopicChangedEvent
+
+	room.CompleteTopic(topicIds[0], "5")
+	_ = <-room.BroadcastChan // discard TopicCompletedEvent
+
+	if _, err := room.NextTopic(); !errors.Is(err, ErrNoTopicsLeft) {
+		t.Error("Advanced without topics left")
+	}
+}
+
+func TestShouldRepairLegacyOrder(t *testing.T) {
+	room, topicIds := newRoomWithTopics(3)
+	room.TopicOrder = []TopicID{topicIds[2]}
+
+	room.hydrate()
+
+	if len(room.TopicOrder) != 3 || room.TopicOrder[0] != topicIds[2] || room.TopicOrder[1] != topicIds[0] {
+		t.Error("Order not repaired")
+	}
+}
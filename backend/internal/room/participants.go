@@ -0,0 +1,131 @@
+package room
+
+import (
+	"errors"
+	"planning-poker/internal/user"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrLastFacilitator    = errors.New("room needs at least one facilitator")
+	ErrObserverCannotVote = errors.New("observers can't vote")
+)
+
+type Participant struct {
+	UserID user.UserID `json:"user_id"`
+	Name   string      `json:"name"`
+	Role   user.Role   `json:"role"`
+}
+
+// Join registers the user as a participant of the room and returns the role it has in it.
+// Returning participants keep their role, the first participant becomes the facilitator and
+// everyone else gets the requested role, as long as it isn't facilitator.
+func (r *Room) Join(u user.User) user.Role {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Participants == nil {
+		r.Participants = make(map[user.UserID]*Participant)
+	}
+
+	participant, ok := r.Participants[u.UserID]
+	if ok {
+		if participant.Name != u.Name {
+			participant.Name = u.Name
+			r.touchParticipant(u.UserID)
+			r.record(r.now(), eventUserJoined, joinRecord{UserID: u.UserID, Name: u.Name, Role: u.Role})
+		}
+		return participant.Role
+	}
+
+	role := user.RoleVoter
+	if !r.hasFacilitator() {
+		role = user.RoleFacilitator
+	} else if u.Role == user.RoleObserver {
+		role = user.RoleObserver
+	}
+
+	r.Participants[u.UserID] = &Participant{
+		UserID: u.UserID,
+		Name:   u.Name,
+		Role:   role,
+	}
+	r.touchParticipant(u.UserID)
+	r.record(r.now(), eventUserJoined, joinRecord{UserID: u.UserID, Name: u.Name, Role: u.Role})
+
+	return role
+}
+
+// UserRole returns the role of a participant, users who never joined the room are treated as voters
+func (r *Room) UserRole(userId user.UserID) user.Role {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.userRole(userId)
+}
+
+func (r *Room) userRole(userId user.UserID) user.Role {
+	participant, ok := r.Participants[userId]
+	if !ok {
+		return user.RoleVoter
+	}
+
+	return participant.Role
+}
+
+func (r *Room) SetUserRole(userId user.UserID, role user.Role) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	participant, ok := r.Participants[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	if participant.Role == user.RoleFacilitator && role != user.RoleFacilitator && r.countRole(user.RoleFacilitator) == 1 {
+		return ErrLastFacilitator
+	}
+
+	participant.Role = role
+	r.touchParticipant(userId)
+
+	// observers don't count towards the estimates, so drop whatever they voted on open topics
+	if role == user.RoleObserver {
+		for _, topic := range r.Topics {
+			if !topic.Completed {
+				delete(topic.ClientVotes, userId)
+				r.touchVote(topic.TopicID, userId)
+			}
+		}
+	}
+
+	event := UserRoleChangedEvent{
+		UserID: userId,
+		Role:   role,
+	}
+	r.record(r.now(), eventUserRoleChanged, event)
+
+	r.BroadcastEvent(event)
+
+	return nil
+}
+
+func (r *Room) hasFacilitator() bool {
+	return r.countRole(user.RoleFacilitator) > 0
+}
+
+func (r *Room) countRole(role user.Role) int {
+	count := 0
+	for _, participant := range r.Participants {
+		if participant.Role == role {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,88 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+func TestShouldMakeFirstParticipantFacilitator(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+
+	first := user.NewUser(ulid.Make(), "first")
+	second := user.NewUser(ulid.Make(), "second")
+	observer := user.NewUser(ulid.Make(), "observer")
+	observer.Role = user.RoleObserver
+
+	if room.Join(first) != user.RoleFacilitator {
+		t.Error("First participant is not the facilitator")
+	}
+
+	if room.Join(second) != user.RoleVoter {
+		t.Error("Second participant is not a voter")
+	}
+
+	if room.Join(observer) != user.RoleObserver {
+		t.Error("Didn't join as observer")
+	}
+
+	// returning participants keep their role
+	if room.Join(first) != user.RoleFacilitator {
+		t.Error("Returning participant lost its role")
+	}
+}
+
+func TestShouldChangeUserRole(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	facilitator := user.NewUser(ulid.Make(), "facilitator")
+	voter := user.NewUser(ulid.Make(), "voter")
+	room.Join(facilitator)
+	room.Join(voter)
+
+	room.VoteOnTopic(voter.UserID, topicId, "5")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+
+	err := room.SetUserRole(voter.UserID, user.RoleObserver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if room.UserRole(voter.UserID) != user.RoleObserver {
+		t.Error("Role not changed")
+	}
+
+	if _, ok := room.Topics[topicId].ClientVotes[voter.UserID]; ok {
+		t.Error("Observer vote was kept")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		if _, ok := ev.Event.(UserRoleChangedEvent); !ok {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+
+	if err := room.VoteOnTopic(voter.UserID, topicId, "5"); !errors.Is(err, ErrObserverCannotVote) {
+		t.Error("Observer was allowed to vote")
+	}
+
+	if err := room.SetUserRole(facilitator.UserID, user.RoleVoter); !errors.Is(err, ErrLastFacilitator) {
+		t.Error("Demoted the last facilitator")
+	}
+
+	if err := room.SetUserRole(voter.UserID, "admin"); !errors.Is(err, ErrInvalidRole) {
+		t.Error("Accepted an invalid role")
+	}
+
+	if err := room.SetUserRole(ulid.Make(), user.RoleVoter); !errors.Is(err, ErrUserNotFound) {
+		t.Error("Changed role of an unknown user")
+	}
+}
@@ -0,0 +1,53 @@
+package room
+
+import "time"
+
+// RoomActivity tells when a room was last changed and whether it was archived for inactivity
+type RoomActivity struct {
+	RoomID         RoomID     `json:"room_id"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	ArchivedAt     *time.Time `json:"archived_at"`
+}
+
+func (r *Room) Activity() RoomActivity {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.activity()
+}
+
+func (r *Room) activity() RoomActivity {
+	activity := RoomActivity{
+		RoomID:         r.RoomID,
+		LastActivityAt: r.LastActivityAt,
+	}
+	if r.ArchivedAt != nil {
+		archivedAt := *r.ArchivedAt
+		activity.ArchivedAt = &archivedAt
+	}
+
+	return activity
+}
+
+// Archive marks a room nobody used for a while, archived rooms get deleted after a while unless they are used again
+func (r *Room) Archive() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.ArchivedAt != nil {
+		return
+	}
+
+	now := r.now()
+	r.ArchivedAt = &now
+	r.record(now, eventRoomArchived, struct{}{})
+}
+
+// markActivity keeps track of the last change made to the room, any change but archiving brings an archived room back
+func (r *Room) markActivity(at time.Time, eventType string) {
+	if eventType != eventRoomArchived {
+		r.LastActivityAt = at
+		r.ArchivedAt = nil
+	}
+	r.touchActivity()
+}
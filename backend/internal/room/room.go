@@ -1,14 +1,23 @@
 package room
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"strings"
 	"sync"
 	"time"
 )
 
+var ErrTopicNotFound = errors.New("topic not found")
+
 type RoomRepo interface {
 	FindRoom(roomId RoomID) (*Room, error)
 	Save(room *Room) error
+	// ListRoomActivity returns when every saved room was last changed, and whether it was archived
+	ListRoomActivity() ([]RoomActivity, error)
+	DeleteRoom(roomId RoomID) error
 }
 
 type CommentID = ulid.ULID
@@ -20,36 +29,69 @@ type Comment struct {
 
 type TopicID = ulid.ULID
 type Topic struct {
-	TopicID      TopicID              `json:"topic_id"`
-	Title        string               `json:"title"`
-	Url          string               `json:"url"`
-	Description  string               `json:"description"`
-	Comments     []Comment            `json:"comments"`
-	ClientVotes  map[ulid.ULID]string `json:"client_votes"`
-	Points       *string              `json:"points"`
-	Completed    bool                 `json:"completed"`
-	VotesVisible bool                 `json:"votes_visible"`
-	CreatedAt    time.Time            `json:"created_at"`
-	CompletedAt  *time.Time           `json:"completed_at"`
+	TopicID        TopicID              `json:"topic_id"`
+	Title          string               `json:"title"`
+	Url            string               `json:"url"`
+	Description    string               `json:"description"`
+	Comments       []Comment            `json:"comments"`
+	ClientVotes    map[ulid.ULID]string `json:"client_votes"`
+	Points         *string              `json:"points"`
+	Completed      bool                 `json:"completed"`
+	VotesVisible   bool                 `json:"votes_visible"`
+	Stats          *VoteStats           `json:"stats"`
+	RoundStartedAt time.Time            `json:"round_started_at"`
+	RevealedAt     *time.Time           `json:"revealed_at"`
+	Rounds         []Round              `json:"rounds"`
+	CreatedAt      time.Time            `json:"created_at"`
+	CompletedAt    *time.Time           `json:"completed_at"`
 }
 
 type RoomID = ulid.ULID
 type Room struct {
-	RoomID         RoomID             `json:"room_id"`
-	CreatedAt      time.Time          `json:"created_at"`
-	Topics         map[TopicID]*Topic `json:"topics"`
-	CurrentTopicID *TopicID           `json:"current_topic_id"`
-	BroadcastChan  chan interface{}   `json:"-"`
-	mutex          sync.Mutex         `json:"-"`
+	RoomID         RoomID                       `json:"room_id"`
+	CreatedAt      time.Time                    `json:"created_at"`
+	Topics         map[TopicID]*Topic           `json:"topics"`
+	TopicOrder     []TopicID                    `json:"topic_order"`
+	CurrentTopicID *TopicID                     `json:"current_topic_id"`
+	Deck           Deck                         `json:"deck"`
+	Timer          *Timer                       `json:"timer"`
+	Participants   map[user.UserID]*Participant `json:"participants"`
+	LastActivityAt time.Time                    `json:"last_activity_at"`
+	ArchivedAt     *time.Time                   `json:"archived_at"`
+	// Version counts the saves of the room, the stores keep it next to the room
+	Version       uint64        `json:"-"`
+	BroadcastChan chan Envelope `json:"-"`
+	mutex         sync.Mutex    `json:"-"`
+
+	// sequencing is guarded by its own lock, since events are also broadcast from outside the room methods
+	eventsMu sync.Mutex
+	seq      uint64
+	events   *eventBuffer
+
+	changes *changeSet
+	lineage ulid.ULID
+
+	// recorded numbers the changes made to the room, the journal keeps the ones not saved yet
+	recorded  uint64
+	journal   []RecordedEvent
+	replaying bool
+	replayAt  time.Time
 }
 
 func NewRoom(id RoomID, topics map[TopicID]*Topic, createdAt time.Time) Room {
 	return Room{
 		RoomID:         id,
 		Topics:         topics,
+		TopicOrder:     initialOrder(topics),
 		CurrentTopicID: nil,
-		BroadcastChan:  make(chan interface{}, 500),
+		Participants:   make(map[user.UserID]*Participant),
+		Deck:           DefaultDeck(),
+		BroadcastChan:  make(chan Envelope, 500),
+		events:         newEventBuffer(eventBufferSize),
 		CreatedAt:      createdAt,
+		LastActivityAt: createdAt,
+		changes:        newRoomChangeSet(),
+		lineage:        ulid.Make(),
 	}
 }
 
@@ -57,53 +99,85 @@ func (r *Room) AddTopic(topicId TopicID, title string, url string, desc string)
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	now := r.now()
+	event := r.addTopic(topicId, title, url, desc, now)
+	r.record(now, eventTopicAdded, event)
+
+	r.BroadcastEvent(event)
+}
+
+// addTopic adds the topic at the end of the ranking and returns the event announcing it, without broadcasting it
+func (r *Room) addTopic(topicId TopicID, title string, url string, desc string, now time.Time) TopicAddedEvent {
 	topic := Topic{
-		TopicID:      topicId,
-		Title:        title,
-		Url:          url,
-		Description:  desc,
-		Comments:     make([]Comment, 0),
-		ClientVotes:  make(map[ulid.ULID]string),
-		Completed:    false,
-		Points:       nil,
-		CreatedAt:    time.Now(),
-		VotesVisible: false,
+		TopicID:        topicId,
+		Title:          title,
+		Url:            url,
+		Description:    desc,
+		Comments:       make([]Comment, 0),
+		ClientVotes:    make(map[ulid.ULID]string),
+		Completed:      false,
+		Points:         nil,
+		CreatedAt:      now,
+		RoundStartedAt: now,
+		Rounds:         make([]Round, 0),
+		VotesVisible:   false,
 	}
 
 	r.Topics[topic.TopicID] = &topic
+	r.TopicOrder = append(r.TopicOrder, topic.TopicID)
+	r.touchTopic(topic.TopicID)
+	r.touchRoom()
 
-	r.BroadcastEvent(TopicAddedEvent{
+	return TopicAddedEvent{
 		TopicID:     topic.TopicID,
 		Title:       topic.Title,
+		Url:         topic.Url,
 		Description: topic.Description,
 		CreatedAt:   topic.CreatedAt,
-	})
+	}
 }
 
-func (r *Room) RemoveTopic(topicId TopicID) {
+func (r *Room) RemoveTopic(topicId TopicID) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if _, ok := r.Topics[topicId]; !ok {
+		return ErrTopicNotFound
+	}
+
 	if r.CurrentTopicID != nil && (*r.CurrentTopicID == topicId) {
 		r.CurrentTopicID = nil
 	}
 
+	r.cancelTimerFor(topicId)
 	delete(r.Topics, topicId)
+	r.removeFromOrder(topicId)
+	r.touchRemovedTopic(topicId)
+	r.touchRoom()
+	r.record(r.now(), eventTopicRemoved, TopicRemovedEvent{TopicID: topicId})
 
 	r.BroadcastEvent(TopicRemovedEvent{TopicID: topicId})
+
+	return nil
 }
 
-func (r *Room) CompleteTopic(topicId TopicID, points string) {
+func (r *Room) CompleteTopic(topicId TopicID, points string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 
 	if !ok {
-		return
+		return ErrTopicNotFound
 	}
 
-	t := time.Now()
+	// cards of custom decks are trimmed too
+	points = strings.TrimSpace(points)
+	if !r.Deck.Contains(points) {
+		return ErrInvalidCard
+	}
+
+	t := r.now()
 	topic.CompletedAt = &t
 	topic.Completed = true
 	topic.Points = &points
@@ -112,119 +186,284 @@ func (r *Room) CompleteTopic(topicId TopicID, points string) {
 		r.CurrentTopicID = nil
 	}
 
-	r.BroadcastEvent(TopicCompletedEvent{
+	r.cancelTimerFor(topicId)
+	r.touchTopic(topicId)
+	r.touchRoom()
+
+	event := TopicCompletedEvent{
 		TopicID: topicId,
 		Points:  points,
-	})
+	}
+	r.record(t, eventTopicCompleted, event)
+
+	r.BroadcastEvent(event)
+
+	return nil
 }
 
-func (r *Room) ResetTopic(topicId TopicID) {
+// ResetTopic archives the current voting round of the topic and starts a new one
+func (r *Room) ResetTopic(topicId TopicID, reason string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
 	}
 
+	now := r.now()
+	r.archiveRound(topic, reason, now)
+	r.touchVotes(topic)
+	r.touchTopic(topicId)
+
 	topic.Points = nil
 	topic.Completed = false
 	topic.CompletedAt = nil
 	topic.ClientVotes = make(map[ulid.ULID]string)
+	topic.Stats = nil
+	topic.RevealedAt = nil
+	topic.RoundStartedAt = now
 
-	r.BroadcastEvent(TopicVotesResetedEvent{TopicID: topicId})
+	event := TopicVotesResetedEvent{
+		TopicID: topicId,
+		Reason:  reason,
+		Round:   len(topic.Rounds) + 1,
+	}
+	r.record(now, eventTopicReset, event)
+
+	r.BroadcastEvent(event)
+
+	return nil
 }
 
-func (r *Room) VoteOnTopic(userId ulid.ULID, topicId TopicID, points string) {
+func (r *Room) VoteOnTopic(userId ulid.ULID, topicId TopicID, points string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
+	}
+
+	if r.userRole(userId) == user.RoleObserver {
+		return ErrObserverCannotVote
+	}
+
+	points = strings.TrimSpace(points)
+	if !r.Deck.Contains(points) {
+		return ErrInvalidCard
 	}
 
 	topic.ClientVotes[userId] = points
+	r.touchVote(topicId, userId)
+	r.record(r.now(), eventTopicVoted, voteRecord{TopicID: topicId, UserID: userId, Points: points})
 
 	r.BroadcastEvent(UserVotedEvent{UserID: userId})
+
+	return nil
 }
 
-func (r *Room) SetCurrentTopic(topicId TopicID) {
+func (r *Room) SetCurrentTopic(topicId TopicID) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
+	}
+
+	r.setCurrentTopic(topic)
+	r.record(r.now(), eventCurrentTopicChanged, CurrentTopicChangedEvent{TopicID: topicId})
+
+	return nil
+}
+
+func (r *Room) setCurrentTopic(topic *Topic) {
+	topicId := topic.TopicID
+
+	// the timer belongs to the topic being voted, so it doesn't carry over to the next one
+	if r.Timer != nil && r.Timer.TopicID != topicId {
+		r.cancelTimer()
 	}
 
 	topic.VotesVisible = false
 	r.CurrentTopicID = &topicId
+	r.touchTopic(topicId)
+	r.touchRoom()
 
 	r.BroadcastEvent(CurrentTopicChangedEvent{TopicID: topicId})
 }
 
-func (r *Room) AddComment(commentId CommentID, topicId TopicID, content string) {
+func (r *Room) AddComment(commentId CommentID, topicId TopicID, content string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
 	}
 
 	comment := Comment{
 		CommentID: commentId,
 		Content:   content,
-		CreatedAt: time.Now(),
+		CreatedAt: r.now(),
 	}
 
 	topic.Comments = append(topic.Comments, comment)
+	r.touchComment(topicId, commentId)
+	r.record(comment.CreatedAt, eventCommentAdded, commentRecord{TopicID: topicId, CommentID: commentId, Content: content})
 
 	r.BroadcastEvent(CommentAddedEvent{
 		CommentID: comment.CommentID,
 		Content:   comment.Content,
 		CreatedAt: comment.CreatedAt,
 	})
+
+	return nil
 }
 
-func (r *Room) ToggleVisibility(topicId TopicID) {
+func (r *Room) ToggleVisibility(topicId TopicID) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
 	}
 
+	now := r.now()
+	r.toggleVisibility(topic, now)
+	r.record(now, eventVisibilityToggled, topicRecord{TopicID: topicId})
+
+	return nil
+}
+
+func (r *Room) toggleVisibility(topic *Topic, now time.Time) {
 	topic.VotesVisible = !topic.VotesVisible
+	r.touchTopic(topic.TopicID)
+
+	// stats are computed on reveal and kept on the topic, so they can be looked at later
+	if topic.VotesVisible {
+		stats := ComputeStats(r.Deck, r.voterVotes(topic))
+		topic.Stats = &stats
+
+		if topic.RevealedAt == nil {
+			revealedAt := now
+			topic.RevealedAt = &revealedAt
+		}
+	}
 
 	r.BroadcastEvent(VisibilityToggled{
-		TopicID: topicId,
+		TopicID: topic.TopicID,
+		Visible: topic.VotesVisible,
+		Stats:   topic.Stats,
 	})
 }
 
-func (r *Room) ChangeTopicDetails(topicId TopicID, title string, desc string, url string) {
+func (r *Room) ChangeTopicDetails(topicId TopicID, title string, desc string, url string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	topic, ok := r.Topics[topicId]
 	if !ok {
-		return
+		return ErrTopicNotFound
 	}
 
 	topic.Title = title
 	topic.Description = desc
 	topic.Url = url
+	r.touchTopic(topicId)
 
-	r.BroadcastEvent(TopicUpdatedEvent{
+	event := TopicUpdatedEvent{
 		TopicID: topicId,
 		Title:   title,
 		Desc:    desc,
 		Url:     url,
-	})
+	}
+	r.record(r.now(), eventTopicUpdated, event)
+
+	r.BroadcastEvent(event)
+
+	return nil
+}
+
+// voterVotes returns the votes of a topic leaving out observers
+func (r *Room) voterVotes(topic *Topic) map[user.UserID]string {
+	votes := make(map[user.UserID]string)
+	for userId, points := range topic.ClientVotes {
+		if r.userRole(userId) != user.RoleObserver {
+			votes[userId] = points
+		}
+	}
+
+	return votes
+}
+
+// ChangeDeck replaces the cards of the room, votes on open topics that aren't part of the new deck are dropped
+func (r *Room) ChangeDeck(deck Deck) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Deck = deck
+	r.touchRoom()
+
+	for _, topic := range r.Topics {
+		if topic.Completed {
+			continue
+		}
+
+		for userId, points := range topic.ClientVotes {
+			if !deck.Contains(points) {
+				delete(topic.ClientVotes, userId)
+				r.touchVote(topic.TopicID, userId)
+			}
+		}
+	}
+
+	r.record(r.now(), eventDeckChanged, DeckChangedEvent{Deck: deck})
+
+	r.BroadcastEvent(DeckChangedEvent{Deck: deck})
 }
 
-func (r *Room) BroadcastEvent(event interface{}) {
-	r.BroadcastChan <- event
+// marshal encodes the room along with the stamp saving it writes
+func (r *Room) marshal() ([]byte, saveStamp, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data, err := json.Marshal(r)
+	return data, r.nextStamp(), err
+}
+
+// hydrate sets up the unexported state of a room loaded from storage and fills in
+// fields that didn't exist when it was saved
+func (r *Room) hydrate() {
+	r.mutex = sync.Mutex{}
+	r.BroadcastChan = make(chan Envelope, 500)
+	r.events = newEventBuffer(eventBufferSize)
+	r.changes = newChangeSet()
+	r.lineage = ulid.Make()
+
+	if r.Topics == nil {
+		r.Topics = make(map[TopicID]*Topic)
+	}
+
+	if r.Participants == nil {
+		r.Participants = make(map[user.UserID]*Participant)
+	}
+
+	if len(r.Deck.Cards) == 0 {
+		r.Deck = DefaultDeck()
+	}
+
+	if r.LastActivityAt.IsZero() {
+		r.LastActivityAt = r.CreatedAt
+	}
+
+	for _, topic := range r.Topics {
+		if topic.RoundStartedAt.IsZero() {
+			topic.RoundStartedAt = topic.CreatedAt
+		}
+	}
+
+	r.repairOrder()
 }
@@ -0,0 +1,224 @@
+package room
+
+import (
+	"encoding/json"
+	"github.com/oklog/ulid/v2"
+	"go.etcd.io/bbolt"
+	"log"
+	"time"
+)
+
+var (
+	roomsBucket = []byte("rooms")
+	// the stamp of every room, kept apart so the rooms stay plain json documents
+	stampsBucket = []byte("room_stamps")
+)
+
+// RoomRepoBolt keeps every room as a json document in a bbolt file, it is pure go so builds don't need cgo
+type RoomRepoBolt struct {
+	db *bbolt.DB
+}
+
+// OpenRoomRepoBolt opens the bolt file at path, creating it when it doesn't exist. Rooms saved before
+// their activity was tracked count as active from then on, as the sql stores do since their migration.
+func OpenRoomRepoBolt(path string) (RoomRepoBolt, error) {
+	// another process holding the file would otherwise block the startup forever
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return RoomRepoBolt{}, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{roomsBucket, stampsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return stampActivity(tx, time.Now())
+	})
+	if err != nil {
+		db.Close()
+		return RoomRepoBolt{}, err
+	}
+
+	return RoomRepoBolt{
+		db: db,
+	}, nil
+}
+
+func (r *RoomRepoBolt) Close() error {
+	return r.db.Close()
+}
+
+func (r *RoomRepoBolt) FindRoom(roomId RoomID) (*Room, error) {
+	var res []byte
+	var stamp *saveStamp
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		// the value is only valid during the transaction
+		res = append(res, tx.Bucket(roomsBucket).Get([]byte(roomId.String()))...)
+
+		var err error
+		stamp, err = boltStamp(tx, roomId)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if res == nil {
+		return nil, nil
+	}
+
+	var room Room
+	err = json.Unmarshal(res, &room)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	room.hydrate()
+	if stamp != nil {
+		room.loadStamp(*stamp)
+	}
+
+	return &room, nil
+}
+
+func (r *RoomRepoBolt) Save(room *Room) error {
+	data, next, err := room.marshal()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		stored, err := boltStamp(tx, room.RoomID)
+		if err != nil {
+			return err
+		}
+		if err := checkStamp(room.RoomID, next, stored); err != nil {
+			return err
+		}
+
+		stamp, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+
+		key := []byte(room.RoomID.String())
+		if err := tx.Bucket(roomsBucket).Put(key, data); err != nil {
+			return err
+		}
+		return tx.Bucket(stampsBucket).Put(key, stamp)
+	})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	room.saved(next)
+
+	return nil
+}
+
+// stampActivity sets the last activity of the rooms saved before it was tracked
+func stampActivity(tx *bbolt.Tx, now time.Time) error {
+	rooms := tx.Bucket(roomsBucket)
+
+	// the bucket can't be written while going through it
+	legacy := make(map[string][]byte)
+	err := rooms.ForEach(func(key, value []byte) error {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return err
+		}
+		if _, ok := doc["last_activity_at"]; ok {
+			return nil
+		}
+
+		at, err := json.Marshal(now.UTC())
+		if err != nil {
+			return err
+		}
+		doc["last_activity_at"] = at
+
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		legacy[string(key)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, data := range legacy {
+		if err := rooms.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// boltStamp reads the stamp of the room, nil when the room isn't stored or was saved before stamps were kept
+func boltStamp(tx *bbolt.Tx, roomId RoomID) (*saveStamp, error) {
+	data := tx.Bucket(stampsBucket).Get([]byte(roomId.String()))
+	if data == nil {
+		return nil, nil
+	}
+
+	var stamp saveStamp
+	if err := json.Unmarshal(data, &stamp); err != nil {
+		return nil, err
+	}
+
+	return &stamp, nil
+}
+
+// ListRoomActivity goes through every saved room, decoding only the fields telling its activity
+func (r *RoomRepoBolt) ListRoomActivity() ([]RoomActivity, error) {
+	activities := make([]RoomActivity, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(roomsBucket).ForEach(func(key, value []byte) error {
+			var doc struct {
+				LastActivityAt time.Time  `json:"last_activity_at"`
+				ArchivedAt     *time.Time `json:"archived_at"`
+			}
+			if err := json.Unmarshal(value, &doc); err != nil {
+				return err
+			}
+
+			roomId, err := ulid.Parse(string(key))
+			if err != nil {
+				return err
+			}
+
+			activities = append(activities, RoomActivity{
+				RoomID:         roomId,
+				LastActivityAt: doc.LastActivityAt,
+				ArchivedAt:     doc.ArchivedAt,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return activities, nil
+}
+
+func (r *RoomRepoBolt) DeleteRoom(roomId RoomID) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(roomId.String())
+		if err := tx.Bucket(roomsBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(stampsBucket).Delete(key)
+	})
+}
@@ -0,0 +1,55 @@
+package room
+
+import (
+	"encoding/json"
+	"github.com/oklog/ulid/v2"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShouldCountLegacyBoltRoomsAsActiveFromOpening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.bolt")
+	repo, err := OpenRoomRepoBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saved before activity was tracked, a year ago
+	roomId := ulid.Make()
+	legacy, _ := json.Marshal(map[string]interface{}{
+		"room_id":    roomId,
+		"created_at": time.Now().AddDate(-1, 0, 0),
+	})
+	err = repo.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(roomsBucket).Put([]byte(roomId.String()), legacy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	opened := time.Now()
+	repo, err = OpenRoomRepoBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	activities, err := repo.ListRoomActivity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].LastActivityAt.Before(opened.Add(-time.Second)) {
+		t.Fatalf("Legacy room not active from opening: %v", activities)
+	}
+
+	room, err := repo.FindRoom(roomId)
+	if err != nil || room == nil {
+		t.Fatal("Legacy room not found", err)
+	}
+	if !room.Activity().LastActivityAt.Equal(activities[0].LastActivityAt) {
+		t.Error("Loaded room disagrees with the listed activity")
+	}
+}
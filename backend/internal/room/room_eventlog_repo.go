@@ -0,0 +1,267 @@
+package room
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// RoomRepoEventLog appends the journal of every room to the room_events table, and rebuilds rooms by replaying
+// the events recorded after their latest snapshot. A snapshot is taken every snapshotEvery events, and whenever
+// the journal of a room misses events the log doesn't have, like when the room was created or the instance
+// saving it dropped some of them.
+type RoomRepoEventLog struct {
+	db            *sql.DB
+	snapshotEvery uint64
+
+	// saves of a room must reach the log in the order they took its journal
+	mu sync.Mutex
+}
+
+func NewRoomRepoEventLog(db *sql.DB, snapshotEvery int) RoomRepoEventLog {
+	return RoomRepoEventLog{
+		db:            db,
+		snapshotEvery: uint64(snapshotEvery),
+	}
+}
+
+func (r *RoomRepoEventLog) FindRoom(roomId RoomID) (*Room, error) {
+	room, err := r.findRoom(roomId, nil)
+	if err == nil && room != nil {
+		var stamp *saveStamp
+		stamp, err = scanStamp(r.db.QueryRow("SELECT version, lineage, recorded FROM room_versions WHERE room_id = ?", roomId.String()))
+		if stamp != nil {
+			room.loadStamp(*stamp)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// FindRoomAt rebuilds the room as it was at the given time, nil when it didn't exist yet
+func (r *RoomRepoEventLog) FindRoomAt(roomId RoomID, at time.Time) (*Room, error) {
+	return r.findRoom(roomId, &at)
+}
+
+func (r *RoomRepoEventLog) findRoom(roomId RoomID, at *time.Time) (*Room, error) {
+	query := "SELECT number, data FROM room_snapshots WHERE room_id = ? ORDER BY number DESC LIMIT 1"
+	args := []interface{}{roomId.String()}
+	if at != nil {
+		query = "SELECT number, data FROM room_snapshots WHERE room_id = ? AND taken_at <= ? ORDER BY number DESC LIMIT 1"
+		args = append(args, at.UTC())
+	}
+
+	var number uint64
+	var data string
+	err := r.db.QueryRow(query, args...).Scan(&number, &data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var room Room
+	err = json.Unmarshal([]byte(data), &room)
+	if err != nil {
+		return nil, err
+	}
+	room.hydrate()
+	room.recorded = number
+
+	events, err := r.events(roomId, number, at)
+	if err != nil {
+		return nil, err
+	}
+
+	err = room.Replay(events)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+// History returns every event recorded for the room, oldest first. Events from before its first
+// snapshot may be missing.
+func (r *RoomRepoEventLog) History(roomId RoomID) ([]RecordedEvent, error) {
+	return r.events(roomId, 0, nil)
+}
+
+// events returns the events of the room after the given number, up to the given time when there is one
+func (r *RoomRepoEventLog) events(roomId RoomID, after uint64, at *time.Time) ([]RecordedEvent, error) {
+	query := "SELECT number, type, data, recorded_at FROM room_events WHERE room_id = ? AND number > ? ORDER BY number"
+	args := []interface{}{roomId.String(), after}
+	if at != nil {
+		query = "SELECT number, type, data, recorded_at FROM room_events WHERE room_id = ? AND number > ? AND recorded_at <= ? ORDER BY number"
+		args = append(args, at.UTC())
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]RecordedEvent, 0)
+	for rows.Next() {
+		var ev RecordedEvent
+		var data string
+		if err := rows.Scan(&ev.Number, &ev.Type, &data, &ev.At); err != nil {
+			return nil, err
+		}
+
+		ev.Data = json.RawMessage(data)
+		events = append(events, ev)
+	}
+
+	return events, rows.Err()
+}
+
+// Save appends the events the room recorded since it was last saved. Instances applying the same changes
+// record them under the same numbers, so events the log already has are skipped.
+func (r *RoomRepoEventLog) Save(room *Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.save(room)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *RoomRepoEventLog) save(room *Room) error {
+	id := room.RoomID.String()
+
+	var lastEvent uint64
+	var lastSnapshot sql.NullInt64
+	err := r.db.QueryRow(
+		`SELECT (SELECT coalesce(max(number), 0) FROM room_events WHERE room_id = ?),
+		(SELECT max(number) FROM room_snapshots WHERE room_id = ?)`,
+		id, id,
+	).Scan(&lastEvent, &lastSnapshot)
+	if err != nil {
+		return err
+	}
+
+	stored := lastEvent
+	if lastSnapshot.Valid && uint64(lastSnapshot.Int64) > stored {
+		stored = uint64(lastSnapshot.Int64)
+	}
+
+	batch, err := room.takeJournal(func(batch journalBatch) bool {
+		first := batch.Recorded + 1 - uint64(len(batch.Events))
+		if !lastSnapshot.Valid || first > stored+1 {
+			return true
+		}
+
+		return batch.Recorded >= uint64(lastSnapshot.Int64)+r.snapshotEvery
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.write(room.RoomID, batch, room.Activity())
+	if err != nil {
+		room.restoreJournal(batch.Events)
+		return err
+	}
+
+	room.saved(batch.Stamp)
+
+	return nil
+}
+
+func (r *RoomRepoEventLog) write(id RoomID, batch journalBatch, activity RoomActivity) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roomId := id.String()
+
+	stored, err := scanStamp(tx.QueryRow("SELECT version, lineage, recorded FROM room_versions WHERE room_id = ?", roomId))
+	if err != nil {
+		return err
+	}
+	if err := checkStamp(id, batch.Stamp, stored); err != nil {
+		return err
+	}
+
+	for _, ev := range batch.Events {
+		_, err := tx.Exec(
+			"INSERT INTO room_events (room_id, number, type, data, recorded_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			roomId, ev.Number, ev.Type, string(ev.Data), ev.At.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if batch.State != nil {
+		_, err := tx.Exec(
+			"INSERT INTO room_snapshots (room_id, number, data, taken_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			roomId, batch.Recorded, string(batch.State), time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO room_activity (room_id, last_activity_at, archived_at) VALUES (?, ?, ?)
+		ON CONFLICT (room_id) DO UPDATE SET last_activity_at = excluded.last_activity_at, archived_at = excluded.archived_at`,
+		roomId, activity.LastActivityAt.UTC(), utcTime(activity.ArchivedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO room_versions (room_id, version, lineage, recorded) VALUES (?, ?, ?, ?)
+		ON CONFLICT (room_id) DO UPDATE SET version = excluded.version, lineage = excluded.lineage, recorded = excluded.recorded`,
+		roomId, batch.Stamp.Version, batch.Stamp.Lineage.String(), batch.Stamp.Recorded,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListRoomActivity returns when every saved room was last changed, oldest first
+func (r *RoomRepoEventLog) ListRoomActivity() ([]RoomActivity, error) {
+	return queryRoomActivity(r.db, "SELECT room_id, last_activity_at, archived_at FROM room_activity ORDER BY last_activity_at")
+}
+
+// DeleteRoom deletes the room with its whole history
+func (r *RoomRepoEventLog) DeleteRoom(roomId RoomID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"room_events", "room_snapshots", "room_activity", "room_versions"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE room_id = ?", roomId.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
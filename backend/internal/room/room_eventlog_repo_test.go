@@ -0,0 +1,220 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+func newTestEventLogRepo(t *testing.T, snapshotEvery int) *RoomRepoEventLog {
+	_, db := newTestSqliteRepo(t)
+
+	repo := NewRoomRepoEventLog(db, snapshotEvery)
+	return &repo
+}
+
+func countRows(t *testing.T, repo *RoomRepoEventLog, table string, roomId RoomID) int {
+	var count int
+	err := repo.db.QueryRow("SELECT count(*) FROM "+table+" WHERE room_id = ?", roomId.String()).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestShouldRebuildRoomFromEventLog(t *testing.T) {
+	repo := newTestEventLogRepo(t, 100)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	playSession(t, &room)
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	if countRows(t, repo, "room_snapshots", room.RoomID) != 1 {
+		t.Error("Expected a single snapshot, taken when the room was created")
+	}
+
+	loaded, err := repo.FindRoom(room.RoomID)
+	if err != nil || loaded == nil {
+		t.Fatal("Room not found", err)
+	}
+
+	if loaded.Recorded() != room.Recorded() || len(loaded.Topics) != len(room.Topics) || len(loaded.Participants) != 2 {
+		t.Fatal("Room not rebuilt")
+	}
+	for topicId, topic := range room.Topics {
+		replayed := loaded.Topics[topicId]
+		if replayed == nil || replayed.Title != topic.Title || replayed.Completed != topic.Completed ||
+			len(replayed.Rounds) != len(topic.Rounds) || len(replayed.Comments) != len(topic.Comments) {
+			t.Errorf("Topic %s not rebuilt", topic.Title)
+		}
+	}
+	if loaded.Deck.Kind != DeckTShirt || *loaded.CurrentTopicID != *room.CurrentTopicID {
+		t.Error("Room not rebuilt")
+	}
+
+	history, err := repo.History(room.RoomID)
+	if err != nil || uint64(len(history)) != room.Recorded() {
+		t.Errorf("Expected %d events in history, got %d", room.Recorded(), len(history))
+	}
+
+	missing, err := repo.FindRoom(ulid.Make())
+	if err != nil || missing != nil {
+		t.Error("Found a room that was never saved")
+	}
+}
+
+func TestShouldSnapshotPeriodically(t *testing.T) {
+	repo := newTestEventLogRepo(t, 5)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Topic", "", "")
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 12; i++ {
+		room.VoteOnTopic(ulid.Make(), topicId, "1")
+		if err := repo.Save(&room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// taken at events 1, 6 and 11
+	if count := countRows(t, repo, "room_snapshots", room.RoomID); count != 3 {
+		t.Errorf("Expected 3 snapshots, got %d", count)
+	}
+
+	loaded, err := repo.FindRoom(room.RoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Topics[topicId].ClientVotes) != 12 || loaded.Recorded() != 13 {
+		t.Error("Room not rebuilt from the latest snapshot")
+	}
+}
+
+func TestShouldRebuildRoomAtPointInTime(t *testing.T) {
+	repo := newTestEventLogRepo(t, 100)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Topic", "", "")
+	room.VoteOnTopic(ulid.Make(), topicId, "3")
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	between := time.Now()
+	time.Sleep(time.Millisecond)
+
+	room.CompleteTopic(topicId, "3")
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := repo.FindRoomAt(room.RoomID, between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == nil || before.Topics[topicId].Completed || len(before.Topics[topicId].ClientVotes) != 1 {
+		t.Error("Wrong room at the point in time")
+	}
+
+	created, err := repo.FindRoomAt(room.RoomID, room.CreatedAt.Add(-time.Hour))
+	if err != nil || created != nil {
+		t.Error("Found the room before it existed")
+	}
+}
+
+func TestShouldSkipEventsSavedByAnotherInstance(t *testing.T) {
+	repo := newTestEventLogRepo(t, 100)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Topic", "", "")
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := room.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := FromSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both instances apply the same votes, each saves after its own
+	alice, bob := ulid.Make(), ulid.Make()
+	for _, r := range []*Room{&room, replica} {
+		r.VoteOnTopic(alice, topicId, "3")
+	}
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*Room{&room, replica} {
+		r.VoteOnTopic(bob, topicId, "5")
+	}
+
+	// the replica is behind the version saved by the other instance, and moves onto it
+	var conflict *ConflictError
+	if err := repo.Save(replica); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if upToDate, err := replica.Rebase(conflict); err != nil || upToDate {
+		t.Fatalf("Expected the replica to rebase, got %v %v", upToDate, err)
+	}
+	if err := repo.Save(replica); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := countRows(t, repo, "room_events", room.RoomID); count != 3 {
+		t.Errorf("Expected 3 events, got %d", count)
+	}
+
+	loaded, _ := repo.FindRoom(room.RoomID)
+	if votes := loaded.Topics[topicId].ClientVotes; votes[alice] != "3" || votes[bob] != "5" {
+		t.Error("Votes not rebuilt")
+	}
+}
+
+func TestShouldSnapshotWhenJournalMissesEvents(t *testing.T) {
+	repo := newTestEventLogRepo(t, 100)
+
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	room.Join(user.NewUser(ulid.Make(), "Alice"))
+	// the events were taken by a save that never made it to the log
+	room.takeJournal(func(journalBatch) bool { return false })
+	room.Join(user.NewUser(ulid.Make(), "Bob"))
+
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.FindRoom(room.RoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Participants) != 2 {
+		t.Error("Events missing from the log were lost")
+	}
+}
@@ -1,23 +1,27 @@
 package room
 
 import (
+	"encoding/json"
 	"sync"
 )
 
+// Storage keeps every room serialized, so changes made to a room only reach it when the room is saved
 type Storage struct {
-	Rooms map[RoomID]Room
+	Rooms map[RoomID][]byte
 }
 
 type RoomRepoMemory struct {
-	db *Storage
+	db     *Storage
+	stamps map[RoomID]saveStamp
 
 	mu sync.Mutex
 }
 
 func NewRoomRepoMemory() RoomRepoMemory {
 	return RoomRepoMemory{
-		db: &Storage{Rooms: make(map[RoomID]Room)},
-		mu: sync.Mutex{},
+		db:     &Storage{Rooms: make(map[RoomID][]byte)},
+		stamps: make(map[RoomID]saveStamp),
+		mu:     sync.Mutex{},
 	}
 }
 
@@ -25,18 +29,75 @@ func (r *RoomRepoMemory) FindRoom(roomId RoomID) (*Room, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	room, ok := r.db.Rooms[roomId]
+	data, ok := r.db.Rooms[roomId]
 	if !ok {
 		return nil, nil
 	}
 
-	return &room, nil
+	room, err := decodeStoredRoom(data)
+	if err != nil {
+		return nil, err
+	}
+	room.loadStamp(r.stamps[roomId])
+
+	return room, nil
 }
 
 func (r *RoomRepoMemory) Save(room *Room) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.db.Rooms[room.RoomID] = *room
+	data, next, err := room.marshal()
+	if err != nil {
+		return err
+	}
+
+	var stored *saveStamp
+	if stamp, ok := r.stamps[room.RoomID]; ok {
+		stored = &stamp
+	}
+	if err := checkStamp(room.RoomID, next, stored); err != nil {
+		return err
+	}
+
+	r.db.Rooms[room.RoomID] = data
+	r.stamps[room.RoomID] = next
+	room.saved(next)
+	return nil
+}
+
+func (r *RoomRepoMemory) ListRoomActivity() ([]RoomActivity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	activities := make([]RoomActivity, 0, len(r.db.Rooms))
+	for _, data := range r.db.Rooms {
+		room, err := decodeStoredRoom(data)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, room.Activity())
+	}
+
+	return activities, nil
+}
+
+func (r *RoomRepoMemory) DeleteRoom(roomId RoomID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.db.Rooms, roomId)
+	delete(r.stamps, roomId)
 	return nil
 }
+
+func decodeStoredRoom(data []byte) (*Room, error) {
+	var room Room
+	err := json.Unmarshal(data, &room)
+	if err != nil {
+		return nil, err
+	}
+
+	room.hydrate()
+	return &room, nil
+}
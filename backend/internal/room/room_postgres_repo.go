@@ -0,0 +1,118 @@
+package room
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// RoomRepoPostgres keeps every room as a jsonb document, connections come from the pool of db
+type RoomRepoPostgres struct {
+	db *sql.DB
+}
+
+func NewRoomRepoPostgres(db *sql.DB) RoomRepoPostgres {
+	return RoomRepoPostgres{
+		db: db,
+	}
+}
+
+func (r *RoomRepoPostgres) FindRoom(roomId RoomID) (*Room, error) {
+	var res []byte
+	var room Room
+	var stamp saveStamp
+	var lineage sql.NullString
+
+	err := r.db.QueryRow(
+		"SELECT data, version, lineage, recorded FROM rooms WHERE id = $1",
+		roomId.String(),
+	).Scan(&res, &stamp.Version, &lineage, &stamp.Recorded)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(res, &room)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	stamp.Lineage, err = parseLineage(lineage)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	room.hydrate()
+	room.loadStamp(stamp)
+
+	return &room, nil
+}
+
+// Save writes the room unless the stored one was saved since the room was loaded, in a single statement
+// so concurrent saves can't both go through
+func (r *RoomRepoPostgres) Save(room *Room) error {
+	err := r.save(room)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *RoomRepoPostgres) save(room *Room) error {
+	data, next, err := room.marshal()
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.Exec(
+		`INSERT INTO rooms (id, data, updated_at, last_activity_at, archived_at, version, lineage, recorded)
+		VALUES ($1, $2::jsonb, now(), ($2::jsonb->>'last_activity_at')::timestamptz, ($2::jsonb->>'archived_at')::timestamptz, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at,
+		last_activity_at = excluded.last_activity_at, archived_at = excluded.archived_at,
+		version = excluded.version, lineage = excluded.lineage, recorded = excluded.recorded
+		WHERE rooms.version = $6`,
+		room.RoomID.String(), data, next.Version, next.Lineage.String(), next.Recorded, next.Version-1,
+	)
+	if err != nil {
+		return err
+	}
+
+	written, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		stored, err := scanStamp(r.db.QueryRow("SELECT version, lineage, recorded FROM rooms WHERE id = $1", room.RoomID.String()))
+		if err != nil {
+			return err
+		}
+		// deleted in the meantime
+		if stored == nil {
+			stored = &saveStamp{}
+		}
+		return newConflictError(room.RoomID, next.Version-1, *stored)
+	}
+
+	room.saved(next)
+
+	return nil
+}
+
+// ListRoomActivity returns when every saved room was last changed, oldest first
+func (r *RoomRepoPostgres) ListRoomActivity() ([]RoomActivity, error) {
+	return queryRoomActivity(r.db, "SELECT id, last_activity_at, archived_at FROM rooms ORDER BY last_activity_at")
+}
+
+func (r *RoomRepoPostgres) DeleteRoom(roomId RoomID) error {
+	_, err := r.db.Exec("DELETE FROM rooms WHERE id = $1", roomId.String())
+	return err
+}
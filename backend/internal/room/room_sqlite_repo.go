@@ -4,11 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/oklog/ulid/v2"
 	"log"
-	"sync"
+	"planning-poker/internal/user"
+	"time"
 )
 
+// RoomRepoSqlite keeps every room across the rooms, participants, topics, votes, comments and rounds tables.
+// Saving a room only writes the rows that changed since it was loaded or last saved.
 type RoomRepoSqlite struct {
 	db *sql.DB
 }
@@ -20,43 +23,655 @@ func NewRoomRepoSqlite(db *sql.DB) RoomRepoSqlite {
 }
 
 func (r *RoomRepoSqlite) FindRoom(roomId RoomID) (*Room, error) {
-	var res []byte
+	room, err := r.findRoom(roomId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// ListRoomIDs returns the id of every saved room
+func (r *RoomRepoSqlite) ListRoomIDs() ([]RoomID, error) {
+	rows, err := r.db.Query("SELECT id FROM rooms ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roomIds := make([]RoomID, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		roomId, err := ulid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		roomIds = append(roomIds, roomId)
+	}
+
+	return roomIds, rows.Err()
+}
+
+// ListRoomActivity returns when every saved room was last changed, oldest first
+func (r *RoomRepoSqlite) ListRoomActivity() ([]RoomActivity, error) {
+	return queryRoomActivity(r.db, "SELECT id, last_activity_at, archived_at FROM rooms ORDER BY last_activity_at")
+}
+
+// DeleteRoom deletes the room with all of its rows
+func (r *RoomRepoSqlite) DeleteRoom(roomId RoomID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM rooms WHERE id = ?", roomId.String())
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"participants", "topics", "votes", "comments", "rounds"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE room_id = ?", roomId.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryRoomActivity reads the room id, last activity and archival time selected by the query
+func queryRoomActivity(db *sql.DB, query string, args ...interface{}) ([]RoomActivity, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := make([]RoomActivity, 0)
+	for rows.Next() {
+		var activity RoomActivity
+		var id string
+		var archivedAt sql.NullTime
+		if err := rows.Scan(&id, &activity.LastActivityAt, &archivedAt); err != nil {
+			return nil, err
+		}
+
+		activity.RoomID, err = ulid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		activity.ArchivedAt = nullTime(archivedAt)
+		activities = append(activities, activity)
+	}
+
+	return activities, rows.Err()
+}
+
+// findRoom reads every table of the room in a single transaction, so it never sees a save half written
+func (r *RoomRepoSqlite) findRoom(roomId RoomID) (*Room, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var room Room
+	var currentTopicId, timer sql.NullString
+	var deckCards, topicOrder string
+	var archivedAt sql.NullTime
+	var stamp saveStamp
+	var lineage sql.NullString
 
-	err := r.db.QueryRow("SELECT data FROM rooms WHERE id = ?", roomId.String()).Scan(&res)
+	err = tx.QueryRow(
+		`SELECT created_at, current_topic_id, deck_kind, deck_cards, timer, topic_order, last_activity_at, archived_at,
+		version, lineage, recorded FROM rooms WHERE id = ?`,
+		roomId.String(),
+	).Scan(
+		&room.CreatedAt, &currentTopicId, &room.Deck.Kind, &deckCards, &timer, &topicOrder, &room.LastActivityAt, &archivedAt,
+		&stamp.Version, &lineage, &stamp.Recorded,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
+		return nil, err
+	}
 
-		log.Println(err)
+	room.RoomID = roomId
+	room.ArchivedAt = nullTime(archivedAt)
+	if currentTopicId.Valid {
+		topicId, err := ulid.Parse(currentTopicId.String)
+		if err != nil {
+			return nil, err
+		}
+		room.CurrentTopicID = &topicId
+	}
+	if err := unmarshalColumn(deckCards, &room.Deck.Cards); err != nil {
+		return nil, err
+	}
+	if err := unmarshalColumn(topicOrder, &room.TopicOrder); err != nil {
 		return nil, err
 	}
+	if timer.Valid {
+		if err := unmarshalColumn(timer.String, &room.Timer); err != nil {
+			return nil, err
+		}
+	}
 
-	err = json.Unmarshal(res, &room)
+	room.Participants, err = findParticipants(tx, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	room.Topics, err = findTopics(tx, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	stamp.Lineage, err = parseLineage(lineage)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
 
-	room.mutex = sync.Mutex{}
-	room.BroadcastChan = make(chan interface{})
+	room.hydrate()
+	room.loadStamp(stamp)
 
 	return &room, nil
 }
 
-func (r *RoomRepoSqlite) Save(room *Room) error {
-	data, err := json.Marshal(room)
+func findParticipants(tx *sql.Tx, roomId RoomID) (map[user.UserID]*Participant, error) {
+	rows, err := tx.Query("SELECT user_id, name, role FROM participants WHERE room_id = ?", roomId.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make(map[user.UserID]*Participant)
+	for rows.Next() {
+		var participant Participant
+		var userId string
+		if err := rows.Scan(&userId, &participant.Name, &participant.Role); err != nil {
+			return nil, err
+		}
+
+		participant.UserID, err = ulid.Parse(userId)
+		if err != nil {
+			return nil, err
+		}
+		participants[participant.UserID] = &participant
+	}
+
+	return participants, rows.Err()
+}
+
+// findTopics loads the topics of the room together with their votes, comments and rounds
+func findTopics(tx *sql.Tx, roomId RoomID) (map[TopicID]*Topic, error) {
+	rows, err := tx.Query(
+		`SELECT id, title, url, description, points, completed, votes_visible, stats, round_started_at, revealed_at, created_at, completed_at
+		FROM topics WHERE room_id = ?`,
+		roomId.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := make(map[TopicID]*Topic)
+	for rows.Next() {
+		topic := Topic{
+			Comments:    make([]Comment, 0),
+			ClientVotes: make(map[ulid.ULID]string),
+			Rounds:      make([]Round, 0),
+		}
+		var topicId string
+		var points, stats sql.NullString
+		var revealedAt, completedAt sql.NullTime
+
+		err := rows.Scan(&topicId, &topic.Title, &topic.Url, &topic.Description, &points, &topic.Completed, &topic.VotesVisible,
+			&stats, &topic.RoundStartedAt, &revealedAt, &topic.CreatedAt, &completedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		topic.TopicID, err = ulid.Parse(topicId)
+		if err != nil {
+			return nil, err
+		}
+		if points.Valid {
+			topic.Points = &points.String
+		}
+		if stats.Valid {
+			if err := unmarshalColumn(stats.String, &topic.Stats); err != nil {
+				return nil, err
+			}
+		}
+		topic.RevealedAt = nullTime(revealedAt)
+		topic.CompletedAt = nullTime(completedAt)
+
+		topics[topic.TopicID] = &topic
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = findVotes(tx, roomId, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	err = findComments(tx, roomId, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	err = findRounds(tx, roomId, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
+func findVotes(tx *sql.Tx, roomId RoomID, topics map[TopicID]*Topic) error {
+	rows, err := tx.Query("SELECT topic_id, user_id, points FROM votes WHERE room_id = ?", roomId.String())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var topicId, userId, points string
+		if err := rows.Scan(&topicId, &userId, &points); err != nil {
+			return err
+		}
+
+		topic, err := rowTopic(topics, topicId)
+		if err != nil {
+			return err
+		}
+		if topic == nil {
+			continue
+		}
+		voterId, err := ulid.Parse(userId)
+		if err != nil {
+			return err
+		}
+		topic.ClientVotes[voterId] = points
+	}
+
+	return rows.Err()
+}
+
+func findComments(tx *sql.Tx, roomId RoomID, topics map[TopicID]*Topic) error {
+	rows, err := tx.Query("SELECT id, topic_id, content, created_at FROM comments WHERE room_id = ? ORDER BY created_at, id", roomId.String())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var comment Comment
+		var commentId, topicId string
+		if err := rows.Scan(&commentId, &topicId, &comment.Content, &comment.CreatedAt); err != nil {
+			return err
+		}
+
+		topic, err := rowTopic(topics, topicId)
+		if err != nil {
+			return err
+		}
+		if topic == nil {
+			continue
+		}
+		comment.CommentID, err = ulid.Parse(commentId)
+		if err != nil {
+			return err
+		}
+		topic.Comments = append(topic.Comments, comment)
+	}
+
+	return rows.Err()
+}
+
+func findRounds(tx *sql.Tx, roomId RoomID, topics map[TopicID]*Topic) error {
+	rows, err := tx.Query(
+		`SELECT topic_id, number, votes, started_at, revealed_at, ended_at, stats, reset_reason
+		FROM rounds WHERE room_id = ? ORDER BY topic_id, number`,
+		roomId.String(),
+	)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
+	defer rows.Close()
 
-	_, err = r.db.Exec("INSERT OR REPLACE INTO rooms (id, data) VALUES (?, ?)", room.RoomID.String(), data)
+	for rows.Next() {
+		var round Round
+		var topicId, votes string
+		var revealedAt, endedAt sql.NullTime
+		var stats sql.NullString
+
+		err := rows.Scan(&topicId, &round.Number, &votes, &round.StartedAt, &revealedAt, &endedAt, &stats, &round.ResetReason)
+		if err != nil {
+			return err
+		}
+
+		topic, err := rowTopic(topics, topicId)
+		if err != nil {
+			return err
+		}
+		if topic == nil {
+			continue
+		}
+		if err := unmarshalColumn(votes, &round.Votes); err != nil {
+			return err
+		}
+		if stats.Valid {
+			if err := unmarshalColumn(stats.String, &round.Stats); err != nil {
+				return err
+			}
+		}
+		round.RevealedAt = nullTime(revealedAt)
+		round.EndedAt = nullTime(endedAt)
+
+		topic.Rounds = append(topic.Rounds, round)
+	}
+
+	return rows.Err()
+}
+
+// rowTopic returns the topic a row belongs to, nil when the room doesn't have it so the row is skipped
+func rowTopic(topics map[TopicID]*Topic, topicId string) (*Topic, error) {
+	id, err := ulid.Parse(topicId)
 	if err != nil {
+		return nil, err
+	}
+
+	return topics[id], nil
+}
+
+// Save writes the rows of the room that changed in a single transaction, unless the stored room was saved
+// since the room was loaded. When it fails the next save writes the whole room, so nothing that changed
+// in between is lost.
+func (r *RoomRepoSqlite) Save(room *Room) error {
+	delta := room.takeDelta()
+
+	err := r.writeDelta(room.RoomID, delta)
+	if err != nil {
+		room.markAllChanged()
 		log.Println(err)
 		return err
 	}
 
+	room.saved(delta.Stamp)
+
 	return nil
 }
+
+func (r *RoomRepoSqlite) writeDelta(roomId RoomID, delta roomDelta) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id := roomId.String()
+
+	stored, err := scanStamp(tx.QueryRow("SELECT version, lineage, recorded FROM rooms WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+	if err := checkStamp(roomId, delta.Stamp, stored); err != nil {
+		return err
+	}
+
+	if delta.Full {
+		for _, table := range []string{"participants", "topics", "votes", "comments", "rounds"} {
+			_, err := tx.Exec("DELETE FROM "+table+" WHERE room_id = ?", id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if delta.Room != nil {
+		err := upsertRoom(tx, delta.Room)
+		if err != nil {
+			return err
+		}
+	} else if delta.Activity != nil {
+		err := updateActivity(tx, *delta.Activity)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, topicId := range delta.RemovedTopics {
+		for _, stmt := range []string{
+			"DELETE FROM topics WHERE id = ?",
+			"DELETE FROM votes WHERE topic_id = ?",
+			"DELETE FROM comments WHERE topic_id = ?",
+			"DELETE FROM rounds WHERE topic_id = ?",
+		} {
+			_, err := tx.Exec(stmt, topicId.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, participant := range delta.Participants {
+		_, err := tx.Exec(
+			`INSERT INTO participants (room_id, user_id, name, role) VALUES (?, ?, ?, ?)
+			ON CONFLICT (room_id, user_id) DO UPDATE SET name = excluded.name, role = excluded.role`,
+			id, participant.UserID.String(), participant.Name, participant.Role,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, topic := range delta.Topics {
+		err := upsertTopic(tx, id, topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, vote := range delta.Votes {
+		if vote.Deleted {
+			_, err = tx.Exec("DELETE FROM votes WHERE topic_id = ? AND user_id = ?", vote.TopicID.String(), vote.UserID.String())
+		} else {
+			_, err = tx.Exec(
+				`INSERT INTO votes (room_id, topic_id, user_id, points) VALUES (?, ?, ?, ?)
+				ON CONFLICT (topic_id, user_id) DO UPDATE SET points = excluded.points`,
+				id, vote.TopicID.String(), vote.UserID.String(), vote.Points,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// comments and rounds never change once added
+	for _, c := range delta.Comments {
+		_, err := tx.Exec(
+			"INSERT INTO comments (id, room_id, topic_id, content, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING",
+			c.Comment.CommentID.String(), id, c.TopicID.String(), c.Comment.Content, c.Comment.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rr := range delta.Rounds {
+		err := insertRound(tx, id, rr)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE rooms SET version = ?, lineage = ?, recorded = ? WHERE id = ?",
+		delta.Stamp.Version, delta.Stamp.Lineage.String(), delta.Stamp.Recorded, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func upsertRoom(tx *sql.Tx, row *roomRow) error {
+	deckCards, err := json.Marshal(row.Deck.Cards)
+	if err != nil {
+		return err
+	}
+
+	topicOrder, err := json.Marshal(row.TopicOrder)
+	if err != nil {
+		return err
+	}
+
+	var timer []byte
+	if row.Timer != nil {
+		timer, err = json.Marshal(row.Timer)
+		if err != nil {
+			return err
+		}
+	}
+
+	var currentTopicId *string
+	if row.CurrentTopicID != nil {
+		id := row.CurrentTopicID.String()
+		currentTopicId = &id
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO rooms (id, created_at, current_topic_id, deck_kind, deck_cards, timer, topic_order, last_activity_at, archived_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET current_topic_id = excluded.current_topic_id, deck_kind = excluded.deck_kind,
+		deck_cards = excluded.deck_cards, timer = excluded.timer, topic_order = excluded.topic_order,
+		last_activity_at = excluded.last_activity_at, archived_at = excluded.archived_at`,
+		row.RoomID.String(), row.CreatedAt, currentTopicId, row.Deck.Kind, string(deckCards), nullString(timer), string(topicOrder),
+		row.LastActivityAt.UTC(), utcTime(row.ArchivedAt),
+	)
+
+	return err
+}
+
+func updateActivity(tx *sql.Tx, activity RoomActivity) error {
+	_, err := tx.Exec(
+		"UPDATE rooms SET last_activity_at = ?, archived_at = ? WHERE id = ?",
+		activity.LastActivityAt.UTC(), utcTime(activity.ArchivedAt), activity.RoomID.String(),
+	)
+
+	return err
+}
+
+func upsertTopic(tx *sql.Tx, roomId string, topic Topic) error {
+	var stats []byte
+	if topic.Stats != nil {
+		var err error
+		stats, err = json.Marshal(topic.Stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO topics (id, room_id, title, url, description, points, completed, votes_visible, stats, round_started_at, revealed_at, created_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, url = excluded.url, description = excluded.description,
+		points = excluded.points, completed = excluded.completed, votes_visible = excluded.votes_visible, stats = excluded.stats,
+		round_started_at = excluded.round_started_at, revealed_at = excluded.revealed_at, completed_at = excluded.completed_at`,
+		topic.TopicID.String(), roomId, topic.Title, topic.Url, topic.Description, topic.Points, topic.Completed, topic.VotesVisible,
+		nullString(stats), topic.RoundStartedAt, topic.RevealedAt, topic.CreatedAt, topic.CompletedAt,
+	)
+
+	return err
+}
+
+func insertRound(tx *sql.Tx, roomId string, rr roundRow) error {
+	votes, err := json.Marshal(rr.Round.Votes)
+	if err != nil {
+		return err
+	}
+
+	var stats []byte
+	if rr.Round.Stats != nil {
+		stats, err = json.Marshal(rr.Round.Stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO rounds (room_id, topic_id, number, votes, started_at, revealed_at, ended_at, stats, reset_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (topic_id, number) DO NOTHING`,
+		roomId, rr.TopicID.String(), rr.Round.Number, string(votes), rr.Round.StartedAt, rr.Round.RevealedAt, rr.Round.EndedAt,
+		nullString(stats), rr.Round.ResetReason,
+	)
+
+	return err
+}
+
+func unmarshalColumn(data string, v interface{}) error {
+	return json.Unmarshal([]byte(data), v)
+}
+
+func nullString(data []byte) sql.NullString {
+	return sql.NullString{String: string(data), Valid: data != nil}
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+// utcTime keeps stored times in UTC, so they compare as text
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+	return &utc
+}
+
+// scanStamp reads the stamp stored with a room, nil when the room isn't stored
+func scanStamp(row *sql.Row) (*saveStamp, error) {
+	var stamp saveStamp
+	var lineage sql.NullString
+	err := row.Scan(&stamp.Version, &lineage, &stamp.Recorded)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stamp.Lineage, err = parseLineage(lineage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stamp, nil
+}
+
+// parseLineage reads a stored lineage, rooms saved before lineages were kept have none
+func parseLineage(lineage sql.NullString) (ulid.ULID, error) {
+	if !lineage.Valid {
+		return ulid.ULID{}, nil
+	}
+
+	return ulid.Parse(lineage.String)
+}
@@ -0,0 +1,176 @@
+package room
+
+import (
+	"database/sql"
+	"github.com/oklog/ulid/v2"
+	"path/filepath"
+	"planning-poker/internal/database"
+	"planning-poker/internal/user"
+	"testing"
+)
+
+func newTestSqliteRepo(t *testing.T) (*RoomRepoSqlite, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "rooms.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRoomRepoSqlite(db)
+	return &repo, db
+}
+
+func TestShouldSaveAndLoadRoomFromSqlite(t *testing.T) {
+	repo, _ := newTestSqliteRepo(t)
+
+	room, topicId := newRoomVotingTopic(t)
+	voter := user.NewUser(ulid.Make(), "Alice")
+	room.Join(voter)
+	room.VoteOnTopic(voter.UserID, topicId, "5")
+	room.AddComment(ulid.Make(), topicId, "looks big")
+	room.ResetTopic(topicId, "recount")
+	room.VoteOnTopic(voter.UserID, topicId, "8")
+
+	if err := repo.Save(room); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.FindRoom(room.RoomID)
+	if err != nil || loaded == nil {
+		t.Fatal("Room not found", err)
+	}
+
+	topic := loaded.Topics[topicId]
+	if topic == nil || topic.Title != "Test topic" || topic.ClientVotes[voter.UserID] != "8" {
+		t.Fatal("Topic not loaded")
+	}
+	if len(topic.Comments) != 1 || topic.Comments[0].Content != "looks big" {
+		t.Error("Comments not loaded")
+	}
+	if len(topic.Rounds) != 1 || topic.Rounds[0].Votes[voter.UserID] != "5" || topic.Rounds[0].ResetReason != "recount" {
+		t.Error("Rounds not loaded")
+	}
+	if loaded.CurrentTopicID == nil || *loaded.CurrentTopicID != topicId || len(loaded.TopicOrder) != 1 {
+		t.Error("Room not loaded")
+	}
+	if p := loaded.Participants[voter.UserID]; p == nil || p.Name != "Alice" || p.Role != user.RoleFacilitator {
+		t.Error("Participants not loaded")
+	}
+	if !loaded.CreatedAt.Equal(room.CreatedAt) {
+		t.Error("Creation time not loaded")
+	}
+
+	missing, err := repo.FindRoom(ulid.Make())
+	if err != nil || missing != nil {
+		t.Error("Found a room that was never saved")
+	}
+}
+
+func TestShouldOnlyWriteChangedRows(t *testing.T) {
+	repo, db := newTestSqliteRepo(t)
+
+	room, topicId := newRoomVotingTopic(t)
+	otherId := ulid.Make()
+	room.AddTopic(otherId, "Other topic", "", "")
+	if err := repo.Save(room); err != nil {
+		t.Fatal(err)
+	}
+
+	// a row the room didn't touch since its last save is left alone
+	if _, err := db.Exec("UPDATE topics SET title = 'Edited elsewhere' WHERE id = ?", otherId.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	voterId := ulid.Make()
+	room.VoteOnTopic(voterId, topicId, "3")
+	if err := repo.Save(room); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.FindRoom(room.RoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Topics[otherId].Title != "Edited elsewhere" {
+		t.Error("Untouched topic was rewritten")
+	}
+	if loaded.Topics[topicId].ClientVotes[voterId] != "3" {
+		t.Error("Vote not saved")
+	}
+
+	// a loaded room only writes what changes after loading
+	loaded.ChangeTopicDetails(topicId, "Renamed", "", "")
+	delta := loaded.takeDelta()
+	if delta.Full || len(delta.Topics) != 1 || delta.Room != nil || len(delta.Votes) != 0 {
+		t.Errorf("Wrong rows changed: %+v", delta)
+	}
+}
+
+func TestShouldDeleteRowsOfRemovedTopic(t *testing.T) {
+	repo, db := newTestSqliteRepo(t)
+
+	room, topicId := newRoomVotingTopic(t)
+	room.VoteOnTopic(ulid.Make(), topicId, "3")
+	room.AddComment(ulid.Make(), topicId, "first")
+	room.ResetTopic(topicId, "recount")
+	if err := repo.Save(room); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := room.RemoveTopic(topicId); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(room); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"topics", "votes", "comments", "rounds"} {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM "+table+" WHERE room_id = ?", room.RoomID.String()).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%d rows left in %s", count, table)
+		}
+	}
+
+	loaded, _ := repo.FindRoom(room.RoomID)
+	if len(loaded.Topics) != 0 || loaded.CurrentTopicID != nil {
+		t.Error("Removed topic loaded")
+	}
+}
+
+func TestShouldReportCorruptRowsInsteadOfPanicking(t *testing.T) {
+	for _, corrupt := range []string{
+		"UPDATE votes SET user_id = 'not a ulid'",
+		"UPDATE comments SET id = 'not a ulid'",
+		"UPDATE rounds SET topic_id = 'not a ulid'",
+	} {
+		t.Run(corrupt, func(t *testing.T) {
+			repo, db := newTestSqliteRepo(t)
+
+			room, topicId := newRoomVotingTopic(t)
+			voter := user.NewUser(ulid.Make(), "Alice")
+			room.Join(voter)
+			room.VoteOnTopic(voter.UserID, topicId, "5")
+			room.AddComment(ulid.Make(), topicId, "looks big")
+			room.ResetTopic(topicId, "recount")
+			room.VoteOnTopic(voter.UserID, topicId, "8")
+
+			if err := repo.Save(room); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(corrupt); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.FindRoom(room.RoomID); err == nil {
+				t.Error("Loaded a room with a corrupt row")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package room
 
 import (
+	"errors"
 	"github.com/oklog/ulid/v2"
 	"testing"
 	"time"
@@ -21,7 +22,7 @@ func TestShouldAddTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(TopicAddedEvent); !ok {
+		if _, ok := ev.Event.(TopicAddedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -48,7 +49,7 @@ func TestShouldRemoveTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(TopicRemovedEvent); !ok {
+		if _, ok := ev.Event.(TopicRemovedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -77,7 +78,7 @@ func TestShouldCompleteTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(TopicCompletedEvent); !ok {
+		if _, ok := ev.Event.(TopicCompletedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -98,7 +99,7 @@ func TestShouldResetTopic(t *testing.T) {
 	room.CompleteTopic(topicId, "5")
 	_ = <-room.BroadcastChan // discard TopicCompletedEvent
 
-	room.ResetTopic(topicId)
+	room.ResetTopic(topicId, "")
 
 	topic := room.Topics[topicId]
 
@@ -108,7 +109,7 @@ func TestShouldResetTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(TopicVotesResetedEvent); !ok {
+		if _, ok := ev.Event.(TopicVotesResetedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -139,7 +140,7 @@ func TestShouldVoteOnTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(UserVotedEvent); !ok {
+		if _, ok := ev.Event.(UserVotedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -165,7 +166,7 @@ func TestShouldSetCurrentTopic(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(CurrentTopicChangedEvent); !ok {
+		if _, ok := ev.Event.(CurrentTopicChangedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -188,7 +189,7 @@ func TestShouldAddComment(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(CommentAddedEvent); !ok {
+		if _, ok := ev.Event.(CommentAddedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -218,7 +219,7 @@ func TestShouldToggleVisibility(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(VisibilityToggled); !ok {
+		if _, ok := ev.Event.(VisibilityToggled); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
@@ -245,10 +246,38 @@ func TestShouldChangeTopicDetails(t *testing.T) {
 
 	select {
 	case ev := <-room.BroadcastChan:
-		if _, ok := ev.(TopicUpdatedEvent); !ok {
+		if _, ok := ev.Event.(TopicUpdatedEvent); !ok {
 			t.Error("Wrong event dispatched")
 		}
 	default:
 		t.Error("Event no dispatched")
 	}
 }
+
+func TestShouldFailOnUnknownTopic(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+
+	errs := []error{
+		room.RemoveTopic(topicId),
+		room.CompleteTopic(topicId, "5"),
+		room.ResetTopic(topicId, ""),
+		room.VoteOnTopic(ulid.Make(), topicId, "5"),
+		room.SetCurrentTopic(topicId),
+		room.AddComment(ulid.Make(), topicId, "comment"),
+		room.ToggleVisibility(topicId),
+		room.ChangeTopicDetails(topicId, "title", "desc", "url"),
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, ErrTopicNotFound) {
+			t.Errorf("Expected ErrTopicNotFound, got %v", err)
+		}
+	}
+
+	select {
+	case <-room.BroadcastChan:
+		t.Error("Event dispatched for unknown topic")
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package room
+
+import (
+	"planning-poker/internal/user"
+	"time"
+)
+
+// Round is a single voting round of a topic, a new one starts every time the topic votes are reset
+type Round struct {
+	Number      int                    `json:"number"`
+	Votes       map[user.UserID]string `json:"votes"`
+	StartedAt   time.Time              `json:"started_at"`
+	RevealedAt  *time.Time             `json:"revealed_at"`
+	EndedAt     *time.Time             `json:"ended_at"`
+	Stats       *VoteStats             `json:"stats"`
+	ResetReason string                 `json:"reset_reason"`
+}
+
+// VotingRounds returns the archived rounds of the topic followed by the round in progress, if anyone voted on it
+func (t *Topic) VotingRounds() []Round {
+	rounds := make([]Round, 0, len(t.Rounds)+1)
+	rounds = append(rounds, t.Rounds...)
+
+	if len(t.ClientVotes) > 0 || t.RevealedAt != nil {
+		rounds = append(rounds, Round{
+			Number:     len(t.Rounds) + 1,
+			Votes:      t.ClientVotes,
+			StartedAt:  t.RoundStartedAt,
+			RevealedAt: t.RevealedAt,
+			Stats:      t.Stats,
+		})
+	}
+
+	return rounds
+}
+
+// archiveRound closes the round in progress and moves it to the topic history
+func (r *Room) archiveRound(topic *Topic, reason string, endedAt time.Time) {
+	if len(topic.ClientVotes) == 0 && topic.RevealedAt == nil {
+		return
+	}
+
+	stats := ComputeStats(r.Deck, r.voterVotes(topic))
+
+	topic.Rounds = append(topic.Rounds, Round{
+		Number:      len(topic.Rounds) + 1,
+		Votes:       topic.ClientVotes,
+		StartedAt:   topic.RoundStartedAt,
+		RevealedAt:  topic.RevealedAt,
+		EndedAt:     &endedAt,
+		Stats:       &stats,
+		ResetReason: reason,
+	})
+	r.touchRound(topic.TopicID, len(topic.Rounds))
+}
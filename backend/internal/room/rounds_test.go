@@ -0,0 +1,60 @@
+package room
+
+import (
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldKeepRoundHistory(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	room.VoteOnTopic(ulid.Make(), topicId, "3")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+	room.VoteOnTopic(ulid.Make(), topicId, "13")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+	room.ToggleVisibility(topicId)
+	_ = <-room.BroadcastChan // discard VisibilityToggled
+
+	room.ResetTopic(topicId, "too far apart")
+	_ = <-room.BroadcastChan // discard TopicVotesResetedEvent
+
+	room.VoteOnTopic(ulid.Make(), topicId, "5")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+
+	topic := room.Topics[topicId]
+
+	if len(topic.Rounds) != 1 {
+		t.Fatal("Round not archived")
+	}
+
+	first := topic.Rounds[0]
+	if first.Number != 1 || len(first.Votes) != 2 || first.RevealedAt == nil || first.EndedAt == nil {
+		t.Error("Wrong archived round")
+	}
+
+	if first.ResetReason != "too far apart" || first.Stats == nil || first.Stats.Consensus != ConsensusSplit {
+		t.Error("Round reason or stats not kept")
+	}
+
+	rounds := topic.VotingRounds()
+	if len(rounds) != 2 || rounds[1].Number != 2 || len(rounds[1].Votes) != 1 || rounds[1].EndedAt != nil {
+		t.Error("Current round not listed")
+	}
+}
+
+func TestShouldNotArchiveEmptyRound(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	room.ResetTopic(topicId, "")
+
+	if len(room.Topics[topicId].Rounds) != 0 || len(room.Topics[topicId].VotingRounds()) != 0 {
+		t.Error("Archived a round nobody voted on")
+	}
+}
@@ -0,0 +1,204 @@
+package room
+
+import (
+	"encoding/json"
+	"github.com/oklog/ulid/v2"
+)
+
+const eventBufferSize = 256
+
+// Envelope is an event together with its position in the room's event stream. Transient events,
+// such as timer ticks, aren't sequenced and have a zero Seq.
+type Envelope struct {
+	Seq   uint64
+	Event interface{}
+}
+
+// eventBuffer is a ring buffer keeping the most recent sequenced events of a room
+type eventBuffer struct {
+	events []Envelope
+	next   int
+	count  int
+}
+
+func newEventBuffer(size int) *eventBuffer {
+	return &eventBuffer{events: make([]Envelope, size)}
+}
+
+func (b *eventBuffer) append(env Envelope) {
+	b.events[b.next] = env
+	b.next = (b.next + 1) % len(b.events)
+	if b.count < len(b.events) {
+		b.count++
+	}
+}
+
+// since returns the buffered events after seq, in order, and false when some of them were already dropped
+func (b *eventBuffer) since(seq uint64, lastSeq uint64) ([]Envelope, bool) {
+	if seq > lastSeq {
+		return nil, false
+	}
+
+	missing := int(lastSeq - seq)
+	if missing > b.count {
+		return nil, false
+	}
+
+	events := make([]Envelope, 0, missing)
+	for i := missing; i > 0; i-- {
+		events = append(events, b.events[(b.next-i+len(b.events))%len(b.events)])
+	}
+
+	return events, true
+}
+
+// Snapshot is the full state of a room at a point of its event stream
+type Snapshot struct {
+	Seq      uint64          `json:"seq"`
+	Recorded uint64          `json:"recorded"`
+	Version  uint64          `json:"version"`
+	Lineage  ulid.ULID       `json:"lineage"`
+	Room     json.RawMessage `json:"room"`
+}
+
+// BroadcastEvent assigns the next sequence number to the event and dispatches it to the room. The event is
+// only kept in the buffer when the broadcaster is behind, as it must not be waited on under the room's locks.
+// The broadcaster catches its clients up from the buffer.
+func (r *Room) BroadcastEvent(event interface{}) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	// nobody is listening to a room being rebuilt from its journal
+	if r.replaying {
+		return
+	}
+
+	r.seq++
+	env := Envelope{Seq: r.seq, Event: event}
+	r.events.append(env)
+
+	select {
+	case r.BroadcastChan <- env:
+	default:
+	}
+}
+
+// broadcastTransient dispatches an event that isn't worth replaying, so it doesn't take a sequence number.
+// It is dropped when the broadcaster is behind, the room is ticked from the broadcaster itself and the next
+// event supersedes it anyway.
+func (r *Room) broadcastTransient(event interface{}) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	if r.replaying {
+		return
+	}
+
+	select {
+	case r.BroadcastChan <- Envelope{Event: event}:
+	default:
+	}
+}
+
+// Epoch names the event stream of the room, sequence numbers only mean something within it. The stream starts
+// over whenever the room is loaded from the store, copies synced from each other share theirs.
+func (r *Room) Epoch() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.lineage.String()
+}
+
+func (r *Room) LastSeq() uint64 {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	return r.seq
+}
+
+// EventsSince returns the events a client that saw everything up to seq has missed,
+// or false when it is too far behind and needs a snapshot instead
+func (r *Room) EventsSince(seq uint64) ([]Envelope, bool) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	return r.events.since(seq, r.seq)
+}
+
+// Snapshot serializes the room together with the sequence number of the last event it reflects
+func (r *Room) Snapshot() (Snapshot, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		Seq:      r.seq,
+		Recorded: r.recorded,
+		Version:  r.Version,
+		Lineage:  r.lineage,
+		Room:     data,
+	}, nil
+}
+
+// Restore replaces the state of the room with a snapshot of another copy of it, such as one that didn't miss
+// changes this one missed, or the one in the store. The events buffered so far are dropped, clients need
+// a snapshot after it.
+func (r *Room) Restore(snapshot Snapshot) error {
+	var restored Room
+	err := json.Unmarshal(snapshot.Room, &restored)
+	if err != nil {
+		return err
+	}
+	restored.hydrate()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	r.Topics = restored.Topics
+	r.TopicOrder = restored.TopicOrder
+	r.CurrentTopicID = restored.CurrentTopicID
+	r.Deck = restored.Deck
+	r.Timer = restored.Timer
+	r.Participants = restored.Participants
+	r.LastActivityAt = restored.LastActivityAt
+	r.ArchivedAt = restored.ArchivedAt
+
+	r.seq = snapshot.Seq
+	r.events = newEventBuffer(eventBufferSize)
+	r.recorded = snapshot.Recorded
+	r.Version = snapshot.Version
+
+	// the journal no longer leads to the room, the stores write it whole
+	r.journal = nil
+	r.trackedChanges().all = true
+
+	return nil
+}
+
+// FromSnapshot restores a room from a snapshot taken by another instance, continuing its event stream
+func FromSnapshot(snapshot Snapshot) (*Room, error) {
+	var r Room
+	err := json.Unmarshal(snapshot.Room, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	r.hydrate()
+	r.seq = snapshot.Seq
+	r.recorded = snapshot.Recorded
+	r.Version = snapshot.Version
+	// the copy goes on with the changes of the one it was synced from
+	r.lineage = snapshot.Lineage
+
+	return &r, nil
+}
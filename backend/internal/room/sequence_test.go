@@ -0,0 +1,144 @@
+package room
+
+import (
+	"encoding/json"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldSequenceEvents(t *testing.T) {
+	room, _ := newRoomWithTopics(2)
+
+	if room.LastSeq() != 2 {
+		t.Errorf("Expected seq 2, got %d", room.LastSeq())
+	}
+
+	room.AddTopic(ulid.Make(), "Test topic", "", "")
+	if ev := <-room.BroadcastChan; ev.Seq != 3 {
+		t.Errorf("Expected seq 3, got %d", ev.Seq)
+	}
+}
+
+func TestShouldNotSequenceTimerTicks(t *testing.T) {
+	room, _ := newRoomVotingTopic(t)
+	if err := room.StartTimer(time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	_ = <-room.BroadcastChan // discard TimerStartedEvent
+	lastSeq := room.LastSeq()
+
+	room.TickTimer(time.Now())
+	if ev := <-room.BroadcastChan; ev.Seq != 0 {
+		t.Errorf("Tick sequenced as %d", ev.Seq)
+	}
+	if room.LastSeq() != lastSeq {
+		t.Error("Tick advanced the sequence")
+	}
+}
+
+func TestShouldReturnEventsSince(t *testing.T) {
+	room, topicIds := newRoomWithTopics(3)
+
+	events, ok := room.EventsSince(1)
+	if !ok {
+		t.Fatal("Expected buffered events")
+	}
+	if len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+		t.Fatalf("Wrong events returned: %v", events)
+	}
+	if ev, ok := events[1].Event.(TopicAddedEvent); !ok || ev.TopicID != topicIds[2] {
+		t.Error("Wrong event returned")
+	}
+
+	events, ok = room.EventsSince(3)
+	if !ok || len(events) != 0 {
+		t.Error("Expected no missed events")
+	}
+}
+
+func TestShouldRequireSnapshotWhenEventsDropped(t *testing.T) {
+	room, _ := newRoomWithTopics(eventBufferSize + 10)
+
+	if _, ok := room.EventsSince(5); ok {
+		t.Error("Expected dropped events to require a snapshot")
+	}
+
+	events, ok := room.EventsSince(10)
+	if !ok || len(events) != eventBufferSize || events[0].Seq != 11 {
+		t.Error("Expected the whole buffer")
+	}
+
+	// a sequence number from before the room was reloaded
+	if _, ok := room.EventsSince(room.LastSeq() + 1); ok {
+		t.Error("Expected a future sequence number to require a snapshot")
+	}
+}
+
+func TestShouldNotBlockWhenBroadcasterIsBehind(t *testing.T) {
+	room, _ := newRoomWithTopics(0)
+	for len(room.BroadcastChan) < cap(room.BroadcastChan) {
+		room.BroadcastChan <- Envelope{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		room.AddTopic(ulid.Make(), "Test topic", "", "")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Blocked on the full broadcast channel")
+	}
+
+	// the dropped event is still buffered for catching up
+	events, ok := room.EventsSince(0)
+	if !ok || len(events) != 1 || events[0].Seq != 1 {
+		t.Errorf("Expected the event buffered, got %v", events)
+	}
+}
+
+func TestShouldSnapshotRoom(t *testing.T) {
+	room, topicIds := newRoomWithTopics(2)
+
+	snapshot, err := room.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Seq != 2 {
+		t.Errorf("Expected seq 2, got %d", snapshot.Seq)
+	}
+
+	var decoded Room
+	if err := json.Unmarshal(snapshot.Room, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.Topics[topicIds[1]]; !ok {
+		t.Error("Topic missing from snapshot")
+	}
+}
+
+func TestShouldRestoreFromSnapshot(t *testing.T) {
+	room, topicIds := newRoomWithTopics(2)
+
+	snapshot, err := room.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := FromSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.OrderedTopics()) != 2 || restored.OrderedTopics()[1].TopicID != topicIds[1] {
+		t.Error("Topics not restored")
+	}
+
+	restored.AddTopic(ulid.Make(), "Test topic", "", "")
+	if ev := <-restored.BroadcastChan; ev.Seq != 3 {
+		t.Errorf("Expected the stream to continue at seq 3, got %d", ev.Seq)
+	}
+}
@@ -0,0 +1,146 @@
+package room
+
+import (
+	"math"
+	"planning-poker/internal/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Consensus string
+
+const (
+	ConsensusNoVotes   Consensus = "no_votes"
+	ConsensusUnanimous Consensus = "unanimous"
+	ConsensusNear      Consensus = "near_consensus"
+	ConsensusSplit     Consensus = "split"
+)
+
+// VoteStats summarizes the votes of a topic at the moment they were revealed. Numeric fields are
+// only set when the deck has numeric cards, and special cards such as "?" never count towards them.
+type VoteStats struct {
+	VoteCount    int            `json:"vote_count"`
+	Average      *float64       `json:"average"`
+	Median       *float64       `json:"median"`
+	Min          *float64       `json:"min"`
+	Max          *float64       `json:"max"`
+	StdDev       *float64       `json:"std_dev"`
+	Distribution map[string]int `json:"distribution"`
+	Consensus    Consensus      `json:"consensus"`
+	Outliers     []user.UserID  `json:"outliers"`
+}
+
+func ComputeStats(deck Deck, votes map[user.UserID]string) VoteStats {
+	stats := VoteStats{
+		VoteCount:    len(votes),
+		Distribution: make(map[string]int),
+		Consensus:    ConsensusNoVotes,
+		Outliers:     make([]user.UserID, 0),
+	}
+
+	// sort voters so outliers always come out in the same order
+	voters := make([]user.UserID, 0, len(votes))
+	for userId, points := range votes {
+		stats.Distribution[points]++
+		voters = append(voters, userId)
+	}
+	sort.Slice(voters, func(i, j int) bool {
+		return voters[i].Compare(voters[j]) < 0
+	})
+
+	var values []float64
+	var indexes []int
+	for _, userId := range voters {
+		points := votes[userId]
+		idx := deck.Index(points)
+		if idx == -1 || IsSpecialCard(points) {
+			continue
+		}
+
+		indexes = append(indexes, idx)
+
+		if value, ok := cardValue(points); ok {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) > 0 {
+		computeNumericStats(&stats, values)
+	}
+
+	if len(indexes) == 0 {
+		return stats
+	}
+
+	sorted := append([]int(nil), indexes...)
+	sort.Ints(sorted)
+	lowest, highest := sorted[0], sorted[len(sorted)-1]
+
+	switch {
+	case lowest == highest:
+		stats.Consensus = ConsensusUnanimous
+	case highest-lowest <= 1:
+		stats.Consensus = ConsensusNear
+	default:
+		stats.Consensus = ConsensusSplit
+
+		// outliers are the voters more than one card away from the median vote
+		median := sorted[(len(sorted)-1)/2]
+		for _, userId := range voters {
+			idx := deck.Index(votes[userId])
+			if idx == -1 || IsSpecialCard(votes[userId]) {
+				continue
+			}
+
+			if idx < median-1 || idx > median+1 {
+				stats.Outliers = append(stats.Outliers, userId)
+			}
+		}
+	}
+
+	return stats
+}
+
+func computeNumericStats(stats *VoteStats, values []float64) {
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	average := sum / float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - average) * (value - average)
+	}
+	stdDev := math.Sqrt(variance / float64(len(values)))
+
+	median := values[len(values)/2]
+	if len(values)%2 == 0 {
+		median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+	}
+
+	min, max := values[0], values[len(values)-1]
+
+	stats.Average = &average
+	stats.Median = &median
+	stats.Min = &min
+	stats.Max = &max
+	stats.StdDev = &stdDev
+}
+
+// cardValue returns the numeric value of a card, if it has one
+func cardValue(card string) (float64, bool) {
+	if card == "½" {
+		return 0.5, true
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(card), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+
+	return value, true
+}
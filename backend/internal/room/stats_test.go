@@ -0,0 +1,81 @@
+package room
+
+import (
+	"github.com/oklog/ulid/v2"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+func TestShouldComputeNumericStats(t *testing.T) {
+	votes := map[user.UserID]string{
+		ulid.Make(): "3",
+		ulid.Make(): "5",
+		ulid.Make(): "5",
+		ulid.Make(): "13",
+		ulid.Make(): CardUnknown,
+	}
+
+	stats := ComputeStats(DefaultDeck(), votes)
+
+	if stats.VoteCount != 5 || stats.Distribution["5"] != 2 || stats.Distribution[CardUnknown] != 1 {
+		t.Error("Wrong vote distribution")
+	}
+
+	if *stats.Average != 6.5 || *stats.Median != 5 || *stats.Min != 3 || *stats.Max != 13 {
+		t.Errorf("Wrong numeric stats: %+v", stats)
+	}
+
+	if stats.Consensus != ConsensusSplit || len(stats.Outliers) != 1 || votes[stats.Outliers[0]] != "13" {
+		t.Errorf("Wrong consensus: %s %v", stats.Consensus, stats.Outliers)
+	}
+}
+
+func TestShouldDetectConsensus(t *testing.T) {
+	unanimous := ComputeStats(DefaultDeck(), map[user.UserID]string{ulid.Make(): "8", ulid.Make(): "8"})
+	if unanimous.Consensus != ConsensusUnanimous {
+		t.Error("Didn't detect unanimous vote")
+	}
+
+	near := ComputeStats(DefaultDeck(), map[user.UserID]string{ulid.Make(): "5", ulid.Make(): "8"})
+	if near.Consensus != ConsensusNear || len(near.Outliers) != 0 {
+		t.Error("Didn't detect near consensus")
+	}
+
+	none := ComputeStats(DefaultDeck(), map[user.UserID]string{ulid.Make(): CardCoffeeBreak})
+	if none.Consensus != ConsensusNoVotes || none.Average != nil {
+		t.Error("Special cards counted as estimates")
+	}
+
+	tshirt, _ := NewDeck(DeckTShirt, nil)
+	sizes := ComputeStats(tshirt, map[user.UserID]string{ulid.Make(): "S", ulid.Make(): "M"})
+	if sizes.Consensus != ConsensusNear || sizes.Average != nil {
+		t.Error("Wrong stats for non numeric deck")
+	}
+}
+
+func TestShouldStoreStatsOnReveal(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+
+	room.VoteOnTopic(ulid.Make(), topicId, "5")
+	_ = <-room.BroadcastChan // discard UserVotedEvent
+
+	room.ToggleVisibility(topicId)
+
+	if room.Topics[topicId].Stats == nil || room.Topics[topicId].Stats.Consensus != ConsensusUnanimous {
+		t.Error("Stats not stored on the topic")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		toggled, ok := ev.Event.(VisibilityToggled)
+		if !ok || !toggled.Visible || toggled.Stats == nil {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+}
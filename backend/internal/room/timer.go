@@ -0,0 +1,245 @@
+package room
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoCurrentTopic  = errors.New("no topic is being voted")
+	ErrTimerNotRunning = errors.New("timer is not running")
+	ErrTimerNotPaused  = errors.New("timer is not paused")
+	ErrInvalidDuration = errors.New("invalid timer duration")
+)
+
+const maxTimerDuration = time.Hour
+
+// timerSkew is how far apart the deadlines of the same timer can be on different instances,
+// timers can only be extended by whole seconds so it stays below that
+const timerSkew = 500 * time.Millisecond
+
+// Timer is the countdown of the topic being voted. While running it has a deadline,
+// while paused it only keeps the time that was left.
+type Timer struct {
+	TopicID     TopicID    `json:"topic_id"`
+	Deadline    *time.Time `json:"deadline"`
+	RemainingMs int64      `json:"remaining_ms"`
+	Paused      bool       `json:"paused"`
+	AutoReveal  bool       `json:"auto_reveal"`
+}
+
+func (t *Timer) remaining(now time.Time) time.Duration {
+	if t.Paused || t.Deadline == nil {
+		return time.Duration(t.RemainingMs) * time.Millisecond
+	}
+
+	remaining := t.Deadline.Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// StartTimer starts a countdown for the current topic, replacing any timer already running
+func (r *Room) StartTimer(duration time.Duration, autoReveal bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.CurrentTopicID == nil {
+		return ErrNoCurrentTopic
+	}
+
+	if duration <= 0 || duration > maxTimerDuration {
+		return ErrInvalidDuration
+	}
+
+	now := r.now()
+	deadline := now.Add(duration)
+	r.Timer = &Timer{
+		TopicID:     *r.CurrentTopicID,
+		Deadline:    &deadline,
+		RemainingMs: duration.Milliseconds(),
+		AutoReveal:  autoReveal,
+	}
+	r.touchRoom()
+	r.record(now, eventTimerStarted, timerRecord{Duration: duration, AutoReveal: autoReveal})
+
+	r.BroadcastEvent(TimerStartedEvent{
+		TopicID:     r.Timer.TopicID,
+		Deadline:    deadline,
+		RemainingMs: r.Timer.RemainingMs,
+		AutoReveal:  autoReveal,
+	})
+
+	return nil
+}
+
+func (r *Room) PauseTimer() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil || r.Timer.Paused {
+		return ErrTimerNotRunning
+	}
+
+	now := r.now()
+	r.Timer.RemainingMs = r.Timer.remaining(now).Milliseconds()
+	r.Timer.Deadline = nil
+	r.Timer.Paused = true
+	r.touchRoom()
+	r.record(now, eventTimerPaused, struct{}{})
+
+	r.BroadcastEvent(TimerPausedEvent{
+		TopicID:     r.Timer.TopicID,
+		RemainingMs: r.Timer.RemainingMs,
+	})
+
+	return nil
+}
+
+func (r *Room) ResumeTimer() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil || !r.Timer.Paused {
+		return ErrTimerNotPaused
+	}
+
+	now := r.now()
+	deadline := now.Add(r.Timer.remaining(now))
+	r.Timer.Deadline = &deadline
+	r.Timer.Paused = false
+	r.touchRoom()
+	r.record(now, eventTimerResumed, struct{}{})
+
+	r.BroadcastEvent(TimerStartedEvent{
+		TopicID:     r.Timer.TopicID,
+		Deadline:    deadline,
+		RemainingMs: r.Timer.RemainingMs,
+		AutoReveal:  r.Timer.AutoReveal,
+	})
+
+	return nil
+}
+
+// ExtendTimer adds time to the timer, whether it is running or paused
+func (r *Room) ExtendTimer(extra time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil {
+		return ErrTimerNotRunning
+	}
+
+	now := r.now()
+	remaining := r.Timer.remaining(now) + extra
+	if extra <= 0 || remaining > maxTimerDuration {
+		return ErrInvalidDuration
+	}
+
+	r.Timer.RemainingMs = remaining.Milliseconds()
+	r.touchRoom()
+	if !r.Timer.Paused {
+		deadline := now.Add(remaining)
+		r.Timer.Deadline = &deadline
+	}
+	r.record(now, eventTimerExtended, timerRecord{Duration: extra})
+
+	r.BroadcastEvent(TimerExtendedEvent{
+		TopicID:     r.Timer.TopicID,
+		Deadline:    r.Timer.Deadline,
+		RemainingMs: r.Timer.RemainingMs,
+	})
+
+	return nil
+}
+
+func (r *Room) CancelTimer() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil {
+		return ErrTimerNotRunning
+	}
+
+	r.cancelTimer()
+	r.record(r.now(), eventTimerCancelled, struct{}{})
+
+	return nil
+}
+
+// TickTimer is called periodically while the room is active, it emits the time left on a running timer.
+// Once the deadline passed it returns the timer, which is then expired with ExpireTimer.
+func (r *Room) TickTimer(now time.Time) *Timer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil || r.Timer.Paused {
+		return nil
+	}
+
+	remaining := r.Timer.remaining(now)
+	if remaining > 0 {
+		r.broadcastTransient(TimerTickEvent{
+			TopicID:     r.Timer.TopicID,
+			RemainingMs: remaining.Milliseconds(),
+		})
+		return nil
+	}
+
+	expired := *r.Timer
+	return &expired
+}
+
+// ExpireTimer stops a timer TickTimer found expired, unless it was paused or extended in the meantime.
+// Instances of a room keep slightly different deadlines, so it doesn't check the deadline against the
+// local clock. Returns true when the room state changed.
+func (r *Room) ExpireTimer(expired Timer) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Timer == nil || r.Timer.Paused || r.Timer.TopicID != expired.TopicID || expired.Deadline == nil {
+		return false
+	}
+	if r.Timer.Deadline.After(expired.Deadline.Add(timerSkew)) {
+		return false
+	}
+
+	now := r.now()
+	timer := r.Timer
+	r.Timer = nil
+	r.touchRoom()
+	r.record(now, eventTimerExpired, *timer)
+
+	r.BroadcastEvent(TimerExpiredEvent{
+		TopicID:    timer.TopicID,
+		AutoReveal: timer.AutoReveal,
+	})
+
+	// reveal through the same path as TOGGLE_VISIBILITY so stats get computed as well
+	topic, ok := r.Topics[timer.TopicID]
+	if timer.AutoReveal && ok && !topic.VotesVisible {
+		r.toggleVisibility(topic, now)
+	}
+
+	return true
+}
+
+func (r *Room) cancelTimer() {
+	if r.Timer == nil {
+		return
+	}
+
+	topicId := r.Timer.TopicID
+	r.Timer = nil
+	r.touchRoom()
+
+	r.BroadcastEvent(TimerCancelledEvent{TopicID: topicId})
+}
+
+func (r *Room) cancelTimerFor(topicId TopicID) {
+	if r.Timer != nil && r.Timer.TopicID == topicId {
+		r.cancelTimer()
+	}
+}
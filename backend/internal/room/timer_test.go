@@ -0,0 +1,161 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func newRoomVotingTopic(t *testing.T) (*Room, TopicID) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	topicId := ulid.Make()
+	room.AddTopic(topicId, "Test topic", "https://google.com", "test desc")
+	_ = <-room.BroadcastChan // discard TopicCreatedEvent
+	room.SetCurrentTopic(topicId)
+	_ = <-room.BroadcastChan // discard CurrentTopicChangedEvent
+
+	return &room, topicId
+}
+
+func TestShouldRunTimer(t *testing.T) {
+	room, topicId := newRoomVotingTopic(t)
+
+	if err := room.StartTimer(time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	_ = <-room.BroadcastChan // discard TimerStartedEvent
+
+	if room.Timer == nil || room.Timer.TopicID != topicId || room.Timer.Deadline == nil {
+		t.Fatal("Timer not started")
+	}
+
+	if room.TickTimer(time.Now()) != nil {
+		t.Error("Timer expired before the deadline")
+	}
+
+	select {
+	case ev := <-room.BroadcastChan:
+		if _, ok := ev.Event.(TimerTickEvent); !ok {
+			t.Error("Wrong event dispatched")
+		}
+	default:
+		t.Error("Event no dispatched")
+	}
+
+	room.PauseTimer()
+	_ = <-room.BroadcastChan // discard TimerPausedEvent
+
+	if !room.Timer.Paused || room.Timer.Deadline != nil {
+		t.Error("Timer not paused")
+	}
+
+	room.ExtendTimer(30 * time.Second)
+	_ = <-room.BroadcastChan // discard TimerExtendedEvent
+
+	if room.Timer.RemainingMs <= time.Minute.Milliseconds() {
+		t.Error("Timer not extended")
+	}
+
+	room.ResumeTimer()
+	_ = <-room.BroadcastChan // discard TimerStartedEvent
+
+	room.CancelTimer()
+
+	if room.Timer != nil {
+		t.Error("Timer not cancelled")
+	}
+
+	if err := room.PauseTimer(); !errors.Is(err, ErrTimerNotRunning) {
+		t.Error("Paused a cancelled timer")
+	}
+}
+
+func TestShouldAutoRevealWhenTimerExpires(t *testing.T) {
+	room, topicId := newRoomVotingTopic(t)
+
+	room.StartTimer(time.Minute, true)
+	_ = <-room.BroadcastChan // discard TimerStartedEvent
+
+	expired := room.TickTimer(time.Now().Add(2 * time.Minute))
+	if expired == nil {
+		t.Fatal("Timer didn't expire")
+	}
+
+	if !room.ExpireTimer(*expired) {
+		t.Error("Timer not expired")
+	}
+
+	if room.Timer != nil {
+		t.Error("Expired timer was kept")
+	}
+
+	if ev := <-room.BroadcastChan; ev.Event != (TimerExpiredEvent{TopicID: topicId, AutoReveal: true}) {
+		t.Error("Wrong event dispatched")
+	}
+
+	if _, ok := (<-room.BroadcastChan).Event.(VisibilityToggled); !ok || !room.Topics[topicId].VotesVisible {
+		t.Error("Votes not revealed")
+	}
+}
+
+func TestShouldDropTicksWhenBroadcasterIsBehind(t *testing.T) {
+	room, _ := newRoomVotingTopic(t)
+
+	if err := room.StartTimer(time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	for len(room.BroadcastChan) < cap(room.BroadcastChan) {
+		room.BroadcastChan <- Envelope{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		room.TickTimer(time.Now())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Tick blocked on a full broadcast channel")
+	}
+}
+
+func TestShouldRequireCurrentTopicForTimer(t *testing.T) {
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+
+	if err := room.StartTimer(time.Minute, false); !errors.Is(err, ErrNoCurrentTopic) {
+		t.Error("Started timer without a topic")
+	}
+
+	roomWithTopic, _ := newRoomVotingTopic(t)
+	if err := roomWithTopic.StartTimer(0, false); !errors.Is(err, ErrInvalidDuration) {
+		t.Error("Started timer without a duration")
+	}
+}
+
+func TestShouldNotExpireExtendedTimer(t *testing.T) {
+	room, _ := newRoomVotingTopic(t)
+
+	room.StartTimer(time.Second, false)
+	_ = <-room.BroadcastChan // discard TimerStartedEvent
+
+	expired := room.TickTimer(time.Now().Add(2 * time.Second))
+	if expired == nil {
+		t.Fatal("Timer didn't expire")
+	}
+
+	// extended before the expiry got applied
+	room.ExtendTimer(time.Minute)
+	_ = <-room.BroadcastChan // discard TimerExtendedEvent
+
+	if room.ExpireTimer(*expired) || room.Timer == nil {
+		t.Error("Expired an extended timer")
+	}
+
+	room.CancelTimer()
+	if room.ExpireTimer(*expired) {
+		t.Error("Expired a cancelled timer")
+	}
+}
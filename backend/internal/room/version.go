@@ -0,0 +1,98 @@
+package room
+
+import (
+	"fmt"
+	"github.com/oklog/ulid/v2"
+)
+
+// saveStamp is kept by every store next to a room, it tells which copy of the room saved it last.
+// Version counts the saves of the room. Lineage is shared by the copies applying the same changes in
+// the same order: a room created or loaded from a store, and the copies synced from it by other instances.
+// Recorded is the number of changes the lineage had recorded when it saved.
+type saveStamp struct {
+	Version  uint64    `json:"version"`
+	Lineage  ulid.ULID `json:"lineage"`
+	Recorded uint64    `json:"recorded"`
+}
+
+// ConflictError is returned by RoomRepo.Save when the room was saved by someone else since the copy being
+// saved was loaded, so saving it would overwrite their changes
+type ConflictError struct {
+	RoomID RoomID
+	// Version is the version the copy being saved is based on, Stored the one in the store
+	Version uint64
+	Stored  uint64
+
+	stored saveStamp
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("room %s was saved at version %d in the meantime, the copy being saved is based on version %d", e.RoomID, e.Stored, e.Version)
+}
+
+func newConflictError(roomId RoomID, version uint64, stored saveStamp) *ConflictError {
+	return &ConflictError{
+		RoomID:  roomId,
+		Version: version,
+		Stored:  stored.Version,
+		stored:  stored,
+	}
+}
+
+// checkStamp refuses to save a copy based on another version than the one stored. A room that isn't stored
+// yet, or anymore, can be saved from any version.
+func checkStamp(roomId RoomID, next saveStamp, stored *saveStamp) error {
+	if stored != nil && stored.Version != next.Version-1 {
+		return newConflictError(roomId, next.Version-1, *stored)
+	}
+
+	return nil
+}
+
+func (r *Room) stampForSave() saveStamp {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.nextStamp()
+}
+
+// nextStamp is the stamp saving the room writes, must be called with the room lock held
+func (r *Room) nextStamp() saveStamp {
+	return saveStamp{
+		Version:  r.Version + 1,
+		Lineage:  r.lineage,
+		Recorded: r.recorded,
+	}
+}
+
+// saved moves the room to the version a save just wrote
+func (r *Room) saved(stamp saveStamp) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Version = stamp.Version
+}
+
+// loadStamp restores the version of a room loaded from a store
+func (r *Room) loadStamp(stamp saveStamp) {
+	r.Version = stamp.Version
+	if stamp.Recorded > r.recorded {
+		r.recorded = stamp.Recorded
+	}
+}
+
+// Rebase moves the room onto the version in the store when it was saved by another copy of the same
+// lineage, like another instance serving the room. Such a copy went through the same changes, so the
+// store has every change of this one when it recorded as many, and there is nothing left to save.
+// Otherwise the room is saved again over the stored version. Conflicts with anyone else are returned.
+func (r *Room) Rebase(conflict *ConflictError) (upToDate bool, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if conflict.stored.Lineage != r.lineage {
+		return false, conflict
+	}
+
+	r.Version = conflict.stored.Version
+	return conflict.stored.Recorded >= r.recorded, nil
+}
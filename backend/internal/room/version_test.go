@@ -0,0 +1,81 @@
+package room
+
+import (
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldRebaseOntoCopiesOfSameLineage(t *testing.T) {
+	repo := NewRoomRepoMemory()
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, _ := room.Snapshot()
+	replica, _ := FromSnapshot(snapshot)
+
+	// both copies apply the same change, the replica saves first
+	topicId := ulid.Make()
+	for _, r := range []*Room{&room, replica} {
+		r.AddTopic(topicId, "Topic", "", "")
+	}
+	if err := repo.Save(replica); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *ConflictError
+	if err := repo.Save(&room); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if upToDate, err := room.Rebase(conflict); err != nil || !upToDate || room.Version != 2 {
+		t.Errorf("Expected the store to have every change, got %v %v", upToDate, err)
+	}
+
+	// the room saves a vote, the replica applies it and goes on with a change the store doesn't have
+	voterId := ulid.Make()
+	room.VoteOnTopic(voterId, topicId, "3")
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+	replica.VoteOnTopic(voterId, topicId, "3")
+	replica.ToggleVisibility(topicId)
+
+	if err := repo.Save(replica); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if upToDate, err := replica.Rebase(conflict); err != nil || upToDate {
+		t.Fatalf("Expected the replica to be saved again, got %v %v", upToDate, err)
+	}
+	if err := repo.Save(replica); err != nil || replica.Version != 4 {
+		t.Errorf("Replica not saved over the stored version: %v", err)
+	}
+}
+
+func TestShouldNotRebaseOntoOtherWriters(t *testing.T) {
+	repo := NewRoomRepoMemory()
+	room := NewRoom(ulid.Make(), make(map[TopicID]*Topic), time.Now())
+	if err := repo.Save(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	// another writer loaded the room on its own
+	snapshot, _ := room.Snapshot()
+	snapshot.Lineage = ulid.Make()
+	other, _ := FromSnapshot(snapshot)
+	other.AddTopic(ulid.Make(), "Elsewhere", "", "")
+	if err := repo.Save(other); err != nil {
+		t.Fatal(err)
+	}
+
+	room.AddTopic(ulid.Make(), "Here", "", "")
+	var conflict *ConflictError
+	if err := repo.Save(&room); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if _, err := room.Rebase(conflict); err != conflict {
+		t.Errorf("Expected the conflict back, got %v", err)
+	}
+}
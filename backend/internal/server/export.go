@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"time"
+)
+
+// ExportRoomHandler returns every topic of the room with its final points, votes and comments
+// as csv, json or markdown
+func (s *Server) ExportRoomHandler(c echo.Context) error {
+	roomId, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+
+	if format != "csv" && format != "json" && format != "md" {
+		return c.JSON(http.StatusBadRequest, "format must be csv, json or md")
+	}
+
+	r, err := s.Hub.FindRoom(roomId)
+	if err != nil {
+		return err
+	}
+
+	if r == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	report := r.Room.Report(time.Now())
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="room-%s.%s"`, roomId.String(), format))
+
+	switch format {
+	case "csv":
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		return report.WriteCSV(c.Response())
+	case "md":
+		c.Response().Header().Set(echo.HeaderContentType, "text/markdown; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		return report.WriteMarkdown(c.Response())
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
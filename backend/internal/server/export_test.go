@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func exportRequest(roomId ulid.ULID, format string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/room/"+roomId.String()+"/export?format="+format, nil)
+}
+
+func TestShouldExportRoom(t *testing.T) {
+	s, roomId, _ := newTestServer(t)
+
+	tests := []struct {
+		format      string
+		contentType string
+	}{
+		{format: "csv", contentType: "text/csv"},
+		{format: "json", contentType: "application/json"},
+		{format: "md", contentType: "text/markdown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			rec := serve(t, s.ExportRoomHandler, exportRequest(roomId, test.format), "id", roomId.String())
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+				t.Errorf("Expected %s, got %s", test.contentType, contentType)
+			}
+
+			disposition := `attachment; filename="room-` + roomId.String() + "." + test.format + `"`
+			if rec.Header().Get("Content-Disposition") != disposition {
+				t.Errorf("Expected %s, got %s", disposition, rec.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+func TestShouldRejectInvalidExports(t *testing.T) {
+	s, roomId, _ := newTestServer(t)
+
+	rec := serve(t, s.ExportRoomHandler, exportRequest(roomId, "pdf"), "id", roomId.String())
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported format, got %d", rec.Code)
+	}
+
+	unknownRoom := ulid.Make()
+	rec = serve(t, s.ExportRoomHandler, exportRequest(unknownRoom, "csv"), "id", unknownRoom.String())
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown room, got %d", rec.Code)
+	}
+}
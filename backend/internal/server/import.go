@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"planning-poker/internal/hub"
+	"planning-poker/internal/room"
+	"strings"
+)
+
+const maxImportBodySize = 1 << 20
+
+// ImportTopicsHandler adds topics in bulk from a CSV or JSON body, the format comes from the
+// format query param or the request content type. Callers identify themselves with their resume token.
+func (s *Server) ImportTopicsHandler(c echo.Context) error {
+	type ImportTopicsResponse struct {
+		Imported int            `json:"imported"`
+		TopicIDs []room.TopicID `json:"topic_ids"`
+	}
+	type ImportErrorResponse struct {
+		Message string          `json:"message"`
+		Errors  []room.RowError `json:"errors,omitempty"`
+	}
+
+	roomId, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	token := c.Request().Header.Get("X-Resume-Token")
+	if token == "" {
+		token = c.QueryParam("resume_token")
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+			format = "csv"
+		}
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBodySize)
+
+	var drafts []room.TopicDraft
+	switch format {
+	case "csv":
+		drafts, err = room.ParseTopicsCSV(body)
+	case "json":
+		drafts, err = room.ParseTopicsJSON(body)
+	default:
+		return c.JSON(http.StatusBadRequest, ImportErrorResponse{Message: "format must be csv or json"})
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, ImportErrorResponse{Message: "import can't be larger than 1 MiB"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, ImportErrorResponse{Message: err.Error()})
+	}
+
+	topicIds, err := s.Hub.ImportTopics(roomId, token, drafts)
+	if err != nil {
+		var importErr *room.ImportError
+		var conflict *room.ConflictError
+
+		switch {
+		case errors.As(err, &importErr):
+			return c.JSON(http.StatusUnprocessableEntity, ImportErrorResponse{Message: "invalid topics", Errors: importErr.Rows})
+		case errors.Is(err, room.ErrEmptyImport):
+			return c.JSON(http.StatusUnprocessableEntity, ImportErrorResponse{Message: err.Error()})
+		case errors.Is(err, hub.ErrRoomNotFound):
+			return c.JSON(http.StatusNotFound, nil)
+		case errors.Is(err, hub.ErrPermissionDenied):
+			return c.JSON(http.StatusForbidden, ImportErrorResponse{Message: err.Error()})
+		case errors.As(err, &conflict):
+			return c.JSON(http.StatusConflict, ImportErrorResponse{Message: "the room was changed elsewhere, the topics weren't saved"})
+		}
+
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ImportTopicsResponse{
+		Imported: len(topicIds),
+		TopicIDs: topicIds,
+	})
+}
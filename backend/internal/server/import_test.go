@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"net/http/httptest"
+	"planning-poker/internal/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func importRequest(roomId ulid.ULID, token string, contentType string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/room/"+roomId.String()+"/topics/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("X-Resume-Token", token)
+	}
+
+	return req
+}
+
+func TestShouldImportTopics(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "csv",
+			contentType: "text/csv",
+			body:        "title,url,description\nLogin,https://example.com/1,\nSignup,,New users\n",
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `[{"title": "Login", "url": "https://example.com/1"}, {"title": "Signup"}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, roomId, token := newTestServer(t)
+
+			rec := serve(t, s.ImportTopicsHandler, importRequest(roomId, token, test.contentType, test.body), "id", roomId.String())
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+
+			var res struct {
+				Imported int `json:"imported"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Imported != 2 {
+				t.Errorf("Expected 2 imported topics, got %s", rec.Body)
+			}
+
+			found, err := s.Hub.FindRoom(roomId)
+			if err != nil || len(found.Room.Topics) != 2 {
+				t.Errorf("Topics not added to the room: %v", err)
+			}
+		})
+	}
+}
+
+func TestShouldRejectInvalidImports(t *testing.T) {
+	s, roomId, token := newTestServer(t)
+
+	unknownRoom := ulid.Make()
+	signer := user.NewTokenSigner(testConfig().SessionSecret, time.Hour)
+	unknownToken, err := signer.Sign(user.NewUser(ulid.Make(), "bob"), unknownRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		roomId ulid.ULID
+		token  string
+		body   string
+		status int
+	}{
+		{name: "too large", roomId: roomId, token: token, body: `[{"title": "` + strings.Repeat("a", maxImportBodySize) + `"}]`, status: http.StatusRequestEntityTooLarge},
+		{name: "missing token", roomId: roomId, body: `[{"title": "Login"}]`, status: http.StatusForbidden},
+		{name: "invalid token", roomId: roomId, token: "garbage", body: `[{"title": "Login"}]`, status: http.StatusForbidden},
+		{name: "malformed file", roomId: roomId, token: token, body: `[{"title": `, status: http.StatusUnprocessableEntity},
+		{name: "invalid rows", roomId: roomId, token: token, body: `[{"title": ""}]`, status: http.StatusUnprocessableEntity},
+		{name: "unknown room", roomId: unknownRoom, token: unknownToken, body: `[{"title": "Login"}]`, status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := importRequest(test.roomId, test.token, "application/json", test.body)
+
+			rec := serve(t, s.ImportTopicsHandler, req, "id", test.roomId.String())
+			if rec.Code != test.status {
+				t.Errorf("Expected %d, got %d: %s", test.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	found, err := s.Hub.FindRoom(roomId)
+	if err != nil || len(found.Room.Topics) != 0 {
+		t.Errorf("Rejected import changed the room: %v", err)
+	}
+}
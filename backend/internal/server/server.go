@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/oklog/ulid/v2"
+	"log"
 	"net/http"
 	"os"
 	"planning-poker/internal/config"
@@ -12,6 +15,7 @@ import (
 	"planning-poker/internal/room"
 	"planning-poker/internal/user"
 	"runtime"
+	"strconv"
 	"time"
 )
 
@@ -37,7 +41,9 @@ func NewServer(cfg config.AppConfig, hub *hub.Hub, roomRepo room.RoomRepo) Serve
 	}
 }
 
-func (s *Server) Serve() {
+// Serve runs the server until ctx is done, then stops accepting connections and shuts the hub down
+// within the configured deadline
+func (s *Server) Serve(ctx context.Context) error {
 	e := echo.New()
 	e.Use(middleware.CORS())
 	e.Use(middleware.Recover())
@@ -45,6 +51,9 @@ func (s *Server) Serve() {
 	e.GET("/ws/:roomId", s.ConnectWS)
 	e.POST("/room", s.CreateRoomHandler)
 	e.GET("/room/:id", s.GetRoomHandler)
+	e.GET("/room/:id/topics/:topicId/rounds", s.GetTopicRoundsHandler)
+	e.POST("/room/:id/topics/import", s.ImportTopicsHandler)
+	e.GET("/room/:id/export", s.ExportRoomHandler)
 
 	e.GET("/metrics", s.GetMetrics)
 
@@ -53,31 +62,74 @@ func (s *Server) Serve() {
 		port = "8080"
 	}
 
-	e.Logger.Fatal(e.Start(":" + port))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- e.Start(":" + port)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	// websockets are hijacked, so this only waits for plain requests. The rooms are saved even when it fails.
+	err := e.Shutdown(shutdownCtx)
+
+	return errors.Join(err, s.Hub.Shutdown(shutdownCtx))
 }
 
 func (s *Server) ConnectWS(c echo.Context) error {
 	roomId := c.Param("roomId")
 	username := c.QueryParam("username")
+	resumeToken := c.QueryParam("resume_token")
 
-	if roomId == "" || username == "" {
+	if roomId == "" || (username == "" && resumeToken == "") {
 		return c.JSON(http.StatusBadRequest, nil)
 	}
 
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	roomUlid, err := ulid.Parse(roomId)
 	if err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, nil)
 	}
 
-	roomUlid, err := ulid.Parse(roomId)
+	u := user.NewUser(ulid.Make(), username)
+	if user.Role(c.QueryParam("role")) == user.RoleObserver {
+		u.Role = user.RoleObserver
+	}
+
+	// keep the same identity when the client hands back the token from a previous AUTH frame,
+	// an invalid token just falls back to a fresh user
+	if resumeToken != "" {
+		resumed, err := s.Hub.ResumeUser(resumeToken, roomUlid)
+		if err == nil {
+			u = resumed
+		} else if username == "" {
+			return c.JSON(http.StatusUnauthorized, nil)
+		}
+	}
+
+	// reconnecting clients tell which events they already saw, and in which stream of the room
+	var seen *hub.StreamPosition
+	if c.QueryParam("last_seq") != "" {
+		seq, err := strconv.ParseUint(c.QueryParam("last_seq"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, nil)
+		}
+		seen = &hub.StreamPosition{Epoch: c.QueryParam("epoch"), Seq: seq}
+	}
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
 
-	userId := ulid.Make()
-	u := user.NewUser(userId, username)
-
-	err = s.Hub.ConnectToRoom(ws, u, roomUlid)
+	err = s.Hub.ConnectToRoom(ws, u, roomUlid, seen)
 	if err != nil {
 		return err
 	}
@@ -89,6 +141,7 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 	type UserResponse struct {
 		UserID user.UserID `json:"user_id"`
 		Name   string      `json:"name"`
+		Role   user.Role   `json:"role"`
 	}
 	type CommentResponse struct {
 		CommentID room.CommentID `json:"comment_id"`
@@ -104,13 +157,17 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 		VotesVisible bool                   `json:"votes_visible"`
 		Points       *string                `json:"points"`
 		ClientVotes  map[user.UserID]string `json:"client_votes"`
+		Stats        *room.VoteStats        `json:"stats"`
+		Rounds       []room.Round           `json:"rounds"`
 		Comments     []CommentResponse      `json:"comments"`
 	}
 	type GetRoomResponse struct {
 		RoomID         room.RoomID                    `json:"room_id"`
 		CreatedAt      time.Time                      `json:"created_at"`
 		Topics         map[room.TopicID]TopicResponse `json:"topics"`
+		TopicOrder     []room.TopicID                 `json:"topic_order"`
 		CurrentTopicID *room.TopicID                  `json:"current_topic_id"`
+		Deck           room.Deck                      `json:"deck"`
 		ConnectedUsers map[user.UserID]UserResponse   `json:"connected_users"`
 	}
 
@@ -131,7 +188,11 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 
 	// parse topics
 	topics := make(map[room.TopicID]TopicResponse)
-	for topicId, topic := range r.Room.Topics {
+	topicOrder := make([]room.TopicID, 0)
+	for _, topic := range r.Room.OrderedTopics() {
+		topicId := topic.TopicID
+		topicOrder = append(topicOrder, topicId)
+
 		comments := make([]CommentResponse, 0)
 		for _, comment := range topic.Comments {
 			comments = append(comments, CommentResponse{
@@ -150,6 +211,8 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 			VotesVisible: topic.VotesVisible,
 			Points:       topic.Points,
 			ClientVotes:  topic.ClientVotes,
+			Stats:        topic.Stats,
+			Rounds:       topic.VotingRounds(),
 			Comments:     comments,
 		}
 	}
@@ -160,6 +223,7 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 		connUsers[connUser.UserID] = UserResponse{
 			UserID: connUser.UserID,
 			Name:   connUser.Name,
+			Role:   connUser.Role,
 		}
 	}
 
@@ -167,28 +231,100 @@ func (s *Server) GetRoomHandler(c echo.Context) error {
 		RoomID:         r.Room.RoomID,
 		CreatedAt:      r.Room.CreatedAt,
 		CurrentTopicID: r.Room.CurrentTopicID,
+		Deck:           r.Room.Deck,
 		Topics:         topics,
+		TopicOrder:     topicOrder,
 		ConnectedUsers: connUsers,
 	}
 
 	return c.JSON(200, json)
 }
 
+func (s *Server) GetTopicRoundsHandler(c echo.Context) error {
+	type GetTopicRoundsResponse struct {
+		TopicID room.TopicID `json:"topic_id"`
+		Rounds  []room.Round `json:"rounds"`
+	}
+
+	roomId, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	topicId, err := ulid.Parse(c.Param("topicId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	r, err := s.Hub.FindRoom(roomId)
+	if err != nil {
+		return err
+	}
+
+	if r == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	topic, ok := r.Room.Topics[topicId]
+	if !ok {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	return c.JSON(http.StatusOK, GetTopicRoundsResponse{
+		TopicID: topicId,
+		Rounds:  topic.VotingRounds(),
+	})
+}
+
 func (s *Server) CreateRoomHandler(c echo.Context) error {
+	type CreateRoomRequest struct {
+		Username string   `json:"username"`
+		Deck     string   `json:"deck"`
+		Cards    []string `json:"cards"`
+	}
 	type CreateRoomResponse struct {
-		RoomID    room.RoomID `json:"room_id"`
-		CreatedAt time.Time   `json:"created_at"`
+		RoomID      room.RoomID  `json:"room_id"`
+		CreatedAt   time.Time    `json:"created_at"`
+		UserID      *user.UserID `json:"user_id,omitempty"`
+		ResumeToken string       `json:"resume_token,omitempty"`
 	}
 
-	r, err := s.Hub.CreateRoom()
+	var req CreateRoomRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	deck := room.DefaultDeck()
+	if req.Deck != "" {
+		deck, err = room.NewDeck(req.Deck, req.Cards)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	// the creator becomes the facilitator of the room when it tells us its name upfront
+	var creator *user.User
+	if req.Username != "" {
+		u := user.NewUser(ulid.Make(), req.Username)
+		creator = &u
+	}
+
+	r, token, err := s.Hub.CreateRoom(creator, deck)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(200, CreateRoomResponse{
-		RoomID:    r.RoomID,
-		CreatedAt: r.CreatedAt,
-	})
+	res := CreateRoomResponse{
+		RoomID:      r.RoomID,
+		CreatedAt:   r.CreatedAt,
+		ResumeToken: token,
+	}
+	if creator != nil {
+		res.UserID = &creator.UserID
+	}
+
+	return c.JSON(200, res)
 }
 
 func (s *Server) GetMetrics(c echo.Context) error {
@@ -197,6 +333,7 @@ func (s *Server) GetMetrics(c echo.Context) error {
 		ConnectedUsers    int                 `json:"connected_users"`
 		CurrentGoroutines int                 `json:"current_goroutines"`
 		Details           map[string][]string `json:"details"`
+		RoomFlushes       hub.FlushStats      `json:"room_flushes"`
 	}
 
 	if c.QueryParam("pw") != s.cfg.AdminPassword {
@@ -208,18 +345,19 @@ func (s *Server) GetMetrics(c echo.Context) error {
 		ConnectedUsers:    0,
 		CurrentGoroutines: runtime.NumGoroutine(),
 		Details:           make(map[string][]string),
+		RoomFlushes:       s.Hub.FlushStats(),
 	}
 
 	s.Hub.Mu.Lock()
 	defer s.Hub.Mu.Unlock()
 
-	for _, activeRoom := range s.Hub.ActiveRooms {
+	for roomId, activeRoom := range s.Hub.ActiveRooms {
 		res.ConnectedRooms++
-		res.Details[activeRoom.Room.RoomID.String()] = []string{}
+		res.Details[roomId.String()] = []string{}
 
 		for _, user := range activeRoom.ConnectedUsers {
 			res.ConnectedUsers++
-			res.Details[activeRoom.Room.RoomID.String()] = append(res.Details[activeRoom.Room.RoomID.String()], user.User.Name)
+			res.Details[roomId.String()] = append(res.Details[roomId.String()], user.User.Name)
 		}
 	}
 
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"net/http"
+	"net/http/httptest"
+	"planning-poker/internal/backplane"
+	"planning-poker/internal/config"
+	"planning-poker/internal/hub"
+	"planning-poker/internal/room"
+	"planning-poker/internal/user"
+	"testing"
+	"time"
+)
+
+func testConfig() config.AppConfig {
+	return config.AppConfig{
+		SessionSecret:  []byte("secret"),
+		ResumeTokenTTL: time.Hour,
+		SendQueueSize:  16,
+		PingInterval:   20 * time.Millisecond,
+		PongTimeout:    100 * time.Millisecond,
+		WriteTimeout:   100 * time.Millisecond,
+	}
+}
+
+// newTestServer serves a single room, created by a facilitator whose resume token is returned
+func newTestServer(t *testing.T) (*Server, room.RoomID, string) {
+	cfg := testConfig()
+	repo := room.NewRoomRepoMemory()
+	h := hub.NewHub(cfg, &repo, backplane.NewMemory())
+
+	creator := user.NewUser(ulid.Make(), "alice")
+	r, token, err := h.CreateRoom(&creator, room.DefaultDeck())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(cfg, &h, &repo)
+	return &s, r.RoomID, token
+}
+
+// serve runs the handler on the request, with the given route params as name and value pairs
+func serve(t *testing.T, handler echo.HandlerFunc, req *http.Request, params ...string) *httptest.ResponseRecorder {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec
+}
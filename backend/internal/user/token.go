@@ -0,0 +1,94 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/oklog/ulid/v2"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid resume token")
+
+type resumeClaims struct {
+	UserID   UserID    `json:"uid"`
+	Name     string    `json:"name"`
+	RoomID   ulid.ULID `json:"rid"`
+	IssuedAt time.Time `json:"iat"`
+}
+
+// TokenSigner issues and verifies the HMAC signed tokens clients use to resume their session in a room.
+// Tokens are accepted for ttl after they were issued.
+type TokenSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewTokenSigner(secret []byte, ttl time.Duration) TokenSigner {
+	return TokenSigner{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+func (s *TokenSigner) Sign(u User, roomId ulid.ULID) (string, error) {
+	payload, err := json.Marshal(resumeClaims{
+		UserID:   u.UserID,
+		Name:     u.Name,
+		RoomID:   roomId,
+		IssuedAt: s.now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + s.signature(encoded), nil
+}
+
+// Verify checks the token signature and returns the user it was issued for, as long as it belongs to roomId
+// and hasn't expired
+func (s *TokenSigner) Verify(token string, roomId ulid.ULID) (User, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return User{}, ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.signature(encoded))) {
+		return User{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return User{}, ErrInvalidToken
+	}
+
+	var claims resumeClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return User{}, ErrInvalidToken
+	}
+
+	if claims.RoomID != roomId {
+		return User{}, ErrInvalidToken
+	}
+
+	if s.now().Sub(claims.IssuedAt) > s.ttl {
+		return User{}, ErrInvalidToken
+	}
+
+	return NewUser(claims.UserID, claims.Name), nil
+}
+
+func (s *TokenSigner) signature(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"github.com/oklog/ulid/v2"
+	"testing"
+	"time"
+)
+
+func TestShouldVerifySignedToken(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	u := NewUser(ulid.Make(), "john")
+	roomId := ulid.Make()
+
+	token, err := signer.Sign(u, roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := signer.Verify(token, roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.UserID != u.UserID || resumed.Name != u.Name {
+		t.Error("Resumed a different user")
+	}
+}
+
+func TestShouldRejectInvalidToken(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	u := NewUser(ulid.Make(), "john")
+	roomId := ulid.Make()
+
+	token, err := signer.Sign(u, roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signer.Verify(token, ulid.Make()); err != ErrInvalidToken {
+		t.Error("Accepted token issued for another room")
+	}
+
+	other := NewTokenSigner([]byte("other secret"), time.Hour)
+	if _, err := other.Verify(token, roomId); err != ErrInvalidToken {
+		t.Error("Accepted token signed with another secret")
+	}
+
+	if _, err := signer.Verify("garbage", roomId); err != ErrInvalidToken {
+		t.Error("Accepted malformed token")
+	}
+}
+
+func TestShouldRejectExpiredToken(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	u := NewUser(ulid.Make(), "john")
+	roomId := ulid.Make()
+
+	issuedAt := time.Now().Add(-2 * time.Hour)
+	signer.now = func() time.Time { return issuedAt }
+	token, err := signer.Sign(u, roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer.now = time.Now
+	if _, err := signer.Verify(token, roomId); err != ErrInvalidToken {
+		t.Error("Accepted expired token")
+	}
+
+	signer.now = func() time.Time { return issuedAt.Add(time.Hour) }
+	if _, err := signer.Verify(token, roomId); err != nil {
+		t.Error("Rejected token before it expired")
+	}
+}
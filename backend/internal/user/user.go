@@ -2,15 +2,29 @@ package user
 
 import "github.com/oklog/ulid/v2"
 
+type Role string
+
+const (
+	RoleFacilitator Role = "facilitator"
+	RoleVoter       Role = "voter"
+	RoleObserver    Role = "observer"
+)
+
+func (role Role) Valid() bool {
+	return role == RoleFacilitator || role == RoleVoter || role == RoleObserver
+}
+
 type UserID = ulid.ULID
 type User struct {
 	UserID UserID
 	Name   string
+	Role   Role
 }
 
 func NewUser(id ulid.ULID, name string) User {
 	return User{
 		UserID: id,
 		Name:   name,
+		Role:   RoleVoter,
 	}
 }